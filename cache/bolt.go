@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+//boltFormat prefixes bucket names, buckets written before values carried their write time are dropped
+const boltFormat = "t1:"
+
+//Bolt is a Store kept in an embedded bbolt database, one bucket per version.
+//Each value is stored after the time it was written, as 8 bytes of big endian unix nanoseconds.
+type Bolt struct {
+	db     *bolt.DB
+	bucket []byte
+	ttl    time.Duration
+
+	hits, misses, evictions int64
+}
+
+//OpenBolt opens or creates the database at path. Buckets of other versions are dropped.
+//Entries expire after ttl, zero keeps them. The database is not bounded in size.
+func OpenBolt(path, version string, ttl time.Duration) (*Bolt, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	//writes are synced by Flush rather than one by one
+	db.NoSync = true
+
+	var b = &Bolt{db: db, bucket: []byte(boltFormat + version), ttl: ttl}
+	err = db.Update(func(tx *bolt.Tx) error {
+		var stale [][]byte
+		tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if string(name) != string(b.bucket) {
+				stale = append(stale, name)
+			}
+			return nil
+		})
+		for _, name := range stale {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.CreateBucketIfNotExists(b.bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+//Get ...
+func (b *Bolt) Get(key string) ([]byte, bool, error) {
+	var v []byte
+	var expired bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var raw = tx.Bucket(b.bucket).Get([]byte(key))
+		if len(raw) < 8 {
+			return nil
+		}
+		if b.ttl > 0 && time.Since(time.Unix(0, int64(binary.BigEndian.Uint64(raw)))) > b.ttl {
+			//left in place, the lookup that follows overwrites it
+			expired = true
+			return nil
+		}
+
+		//values are only valid inside the transaction
+		v = append([]byte(nil), raw[8:]...)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	if expired {
+		atomic.AddInt64(&b.evictions, 1)
+	}
+	if v == nil {
+		atomic.AddInt64(&b.misses, 1)
+		return nil, false, nil
+	}
+
+	atomic.AddInt64(&b.hits, 1)
+	return v, true, nil
+}
+
+//Set ...
+func (b *Bolt) Set(key string, value []byte) error {
+	var v = make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(v, uint64(time.Now().UnixNano()))
+	copy(v[8:], value)
+
+	//Batch coalesces the writes of concurrent workers into one transaction
+	return b.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.bucket).Put([]byte(key), v)
+	})
+}
+
+//Stats leaves Len at zero, counting the keys would walk the whole bucket
+func (b *Bolt) Stats() Stats {
+	return Stats{
+		Hits:      atomic.LoadInt64(&b.hits),
+		Misses:    atomic.LoadInt64(&b.misses),
+		Evictions: atomic.LoadInt64(&b.evictions),
+	}
+}
+
+//Flush ...
+func (b *Bolt) Flush() error {
+	return b.db.Sync()
+}
+
+//Close ...
+func (b *Bolt) Close() error {
+	if err := b.db.Sync(); err != nil {
+		b.db.Close()
+		return err
+	}
+
+	return b.db.Close()
+}
@@ -2,101 +2,308 @@ package cache
 
 import (
 	"bufio"
+	"container/list"
+	"encoding/csv"
 	"fmt"
 	"io"
 	"os"
-	"strings"
+	"path/filepath"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-//Cache ...
+//header starts every cache file, files without it were written by an older version and are ignored
+const header = "#cache v4"
+
+//shards spreads entries over independently locked parts, so workers rarely wait on each other
+const shards = 64
+
+//entry is a cached value, the version it was written under and when it was added
+type entry struct {
+	key     string
+	value   []byte
+	version string
+	added   time.Time
+}
+
+//shard is a least recently used list of the entries hashing to it
+type shard struct {
+	sync.Mutex
+	c   map[string]*list.Element
+	lru *list.List
+
+	//counted under the lock, shared counters would make every lookup contend again
+	hits, misses, evictions int64
+}
+
+//Cache is an in memory Store, a sharded least recently used cache that is unbounded until SetLimits is called
 type Cache struct {
-	c map[string][]string
-	*sync.RWMutex
+	shards [shards]*shard
+
+	//max is the bound per shard and ttl the age entries expire at, zero means no bound
+	max int
+	ttl time.Duration
+
+	//path is where the cache is saved, version is written with new entries
+	path    string
+	version string
+	dirty   int32
+	save    sync.Mutex
 }
 
-//New checks for saved cache file and loads, if exists, or creates empty cache
+//New creates an empty cache that is kept in memory only
 func New() *Cache {
-	var c *Cache
-	//	c, err := fromDisk()
-	//	if err != nil {
-	c = &Cache{
-		make(map[string][]string),
-		new(sync.RWMutex),
+	var c = new(Cache)
+	for i := range c.shards {
+		c.shards[i] = &shard{c: make(map[string]*list.Element), lru: list.New()}
 	}
-	//}
 
 	return c
 }
 
-//Flush writes cache contents to disk
+//Open loads the cache saved at path, keeping only entries written under version, e.g. the build
+//of the database values were looked up in. A missing file gives an empty cache that Flush will create.
+func Open(path, version string) (*Cache, error) {
+	var c = New()
+	c.path = path
+	c.version = version
+
+	if err := c.fromDisk(); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return c, nil
+}
+
+//SetLimits keeps about max entries, evicting the least recently used, and expires entries
+//older than ttl. Zero leaves either unbounded. Entries over the new limits are evicted right away.
+//It must be called before the cache is shared.
+func (c *Cache) SetLimits(max int, ttl time.Duration) {
+	//the bound is kept per shard, rounding up so small caches still hold max entries. Keys hashing
+	//unevenly may be evicted a little before max is reached.
+	c.max = 0
+	if max > 0 {
+		c.max = (max + shards - 1) / shards
+	}
+	c.ttl = ttl
+
+	var now = time.Now()
+	for _, s := range c.shards {
+		s.Lock()
+		for el := s.lru.Back(); el != nil; {
+			var prev = el.Prev()
+			if c.over(s) || c.expired(el.Value.(*entry), now) {
+				c.remove(s, el)
+			}
+			el = prev
+		}
+		s.Unlock()
+	}
+}
+
+//Len returns the number of cached entries
+func (c *Cache) Len() int {
+	return c.Stats().Len
+}
+
+//Stats returns the lookup counters
+func (c *Cache) Stats() Stats {
+	var st Stats
+	for _, s := range c.shards {
+		s.Lock()
+		st.Hits += s.hits
+		st.Misses += s.misses
+		st.Evictions += s.evictions
+		st.Len += len(s.c)
+		s.Unlock()
+	}
+
+	return st
+}
+
+//Flush writes cache contents to disk if they changed, a cache created by New has nowhere to go
 func (c *Cache) Flush() error {
+	if c.path == "" {
+		return nil
+	}
+
 	return c.toDisk()
 }
 
-//Add ...
-func (c *Cache) Add(ip, city, country string) {
-	c.Lock()
-	defer c.Unlock()
+//Set ...
+func (c *Cache) Set(key string, value []byte) error {
+	var s = c.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	c.put(s, &entry{key, value, c.version, time.Now()})
+	c.changed()
 
-	c.c[ip] = []string{city, country}
+	return nil
+}
+
+//Get ...
+func (c *Cache) Get(key string) ([]byte, bool, error) {
+	var s = c.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	v, ok := c.get(s, key)
+	if !ok {
+		s.misses++
+		return nil, false, nil
+	}
 
-	return
+	s.hits++
+	return v.value, true, nil
 }
 
-//Load ...
-func (c *Cache) Load(ip string) (string, string, bool) {
-	c.RLock()
-	defer c.RUnlock()
+//Close saves the cache
+func (c *Cache) Close() error {
+	return c.Flush()
+}
 
-	v, ok := c.c[ip]
+//get finds key in s without counting the lookup, dropping it when expired. The caller holds the lock.
+func (c *Cache) get(s *shard, key string) (*entry, bool) {
+	el, ok := s.c[key]
 	if !ok {
-		return "", "", false
+		return nil, false
+	}
+
+	var v = el.Value.(*entry)
+	if c.ttl > 0 && c.expired(v, time.Now()) {
+		c.remove(s, el)
+		return nil, false
 	}
 
-	if len(v) != 2 {
-		return "", "", false
+	s.lru.MoveToFront(el)
+	return v, true
+}
+
+//shard picks the shard of key with an inlined fnv-1a hash
+func (c *Cache) shard(key string) *shard {
+	var h uint32 = 2166136261
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
 	}
 
-	return v[0], v[1], true
+	return c.shards[h%shards]
 }
 
-func fromDisk() (*Cache, error) {
-	var c = make(map[string][]string)
+//put stores e at the front of s, evicting from the back while over the bound, the caller holds the lock
+func (c *Cache) put(s *shard, e *entry) {
+	if el, ok := s.c[e.key]; ok {
+		el.Value = e
+		s.lru.MoveToFront(el)
+		return
+	}
+
+	s.c[e.key] = s.lru.PushFront(e)
+	for c.over(s) {
+		c.remove(s, s.lru.Back())
+	}
+}
+
+//over reports whether s holds more entries than allowed
+func (c *Cache) over(s *shard) bool {
+	return c.max > 0 && s.lru.Len() > c.max
+}
 
+//expired reports whether e outlived the ttl
+func (c *Cache) expired(e *entry, now time.Time) bool {
+	return c.ttl > 0 && now.Sub(e.added) > c.ttl
+}
+
+//remove evicts el from s, the caller holds the lock
+func (c *Cache) remove(s *shard, el *list.Element) {
+	s.lru.Remove(el)
+	delete(s.c, el.Value.(*entry).key)
+	s.evictions++
+	c.changed()
+}
+
+//changed marks the cache for saving, reading first so that shards do not all write the same word
+func (c *Cache) changed() {
+	if atomic.LoadInt32(&c.dirty) == 0 {
+		atomic.StoreInt32(&c.dirty, 1)
+	}
+}
+
+//fromDisk reads key,value,version,added csv records, most recently used first, dropping those of another version
+func (c *Cache) fromDisk() error {
 	//open cache file
-	fp, err := os.OpenFile(".cache", os.O_RDONLY, 0766)
+	fp, err := os.Open(c.path)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
 	defer fp.Close()
 
 	//create reader buffer
 	var r = bufio.NewReader(fp)
+	first, err := r.ReadString('\n')
+	if err == io.EOF || first != header+"\n" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var cr = csv.NewReader(r)
+	cr.FieldsPerRecord = 4
 	for {
-		line, err := r.ReadString('\n')
+		rec, err := cr.Read()
 		if err == io.EOF {
 			break
 		}
-
 		if err != nil {
-			return nil, err
+			return err
+		}
+
+		if rec[2] != c.version {
+			//written by another version, look it up again
+			continue
 		}
 
-		var spl = strings.Split(line, ",")
-		c[spl[0]] = []string{spl[1], spl[2]}
+		var s = c.shard(rec[0])
+		if _, ok := s.c[rec[0]]; ok {
+			continue
+		}
+
+		added, err := strconv.ParseInt(rec[3], 10, 64)
+		if err != nil {
+			return err
+		}
+		s.c[rec[0]] = s.lru.PushBack(&entry{rec[0], []byte(rec[1]), rec[2], time.Unix(added, 0)})
 	}
 
-	return &Cache{
-		c,
-		new(sync.RWMutex),
-	}, nil
+	return nil
 }
 
+//toDisk replaces the cache file through a temporary file, so a crash never leaves a partial cache behind.
+//Shards are locked one at a time, lookups carry on while the others are written.
 func (c *Cache) toDisk() error {
-	//create file in necessary
-	fp, err := os.OpenFile(".cache", os.O_RDWR|os.O_CREATE, 0766)
+	c.save.Lock()
+	defer c.save.Unlock()
+
+	if !atomic.CompareAndSwapInt32(&c.dirty, 1, 0) {
+		return nil
+	}
+
+	var err = c.writeFile()
+	if err != nil {
+		//try again next time
+		atomic.StoreInt32(&c.dirty, 1)
+	}
+
+	return err
+}
+
+//writeFile writes every shard, most recently used first, to the cache file
+func (c *Cache) writeFile() error {
+	fp, err := os.Create(c.path + ".tmp")
 	if err != nil {
 		return err
 	}
@@ -104,11 +311,40 @@ func (c *Cache) toDisk() error {
 
 	//create writer buffer
 	var w = bufio.NewWriter(fp)
-	for k, v := range c.c {
-		var line = fmt.Sprintf("%s,%s,%s\n", k, v[0], v[1])
-		w.WriteString(line)
+	w.WriteString(header + "\n")
+
+	//csv quoting keeps commas and quotes in values intact, only a \r\n inside a value would be read back as \n
+	var cw = csv.NewWriter(w)
+	for _, s := range c.shards {
+		s.Lock()
+		for el := s.lru.Front(); el != nil; el = el.Next() {
+			var v = el.Value.(*entry)
+			cw.Write([]string{v.key, string(v.value), v.version, strconv.FormatInt(v.added.Unix(), 10)})
+		}
+		s.Unlock()
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
 	}
 
 	//flush contents to disk
-	return w.Flush()
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := fp.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(c.path+".tmp", c.path); err != nil {
+		return err
+	}
+
+	//make the rename durable
+	dir, err := os.Open(filepath.Dir(c.path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
 }
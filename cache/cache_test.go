@@ -0,0 +1,293 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//rwCache is the cache as it was before sharding, one least recently used list behind a single lock,
+//kept to benchmark against
+type rwCache struct {
+	c map[string]*list.Element
+	*sync.RWMutex
+	lru *list.List
+	max int
+
+	hits, misses, evictions int64
+}
+
+func newRWCache(max int) *rwCache {
+	return &rwCache{c: make(map[string]*list.Element), RWMutex: new(sync.RWMutex), lru: list.New(), max: max}
+}
+
+func (c *rwCache) Get(key string) ([]byte, bool, error) {
+	//a hit reorders the list, so even lookups take the write lock
+	c.Lock()
+	defer c.Unlock()
+
+	el, ok := c.c[key]
+	if !ok {
+		c.misses++
+		return nil, false, nil
+	}
+
+	c.lru.MoveToFront(el)
+	c.hits++
+	return el.Value.(*entry).value, true, nil
+}
+
+func (c *rwCache) Set(key string, value []byte) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if el, ok := c.c[key]; ok {
+		el.Value = &entry{key: key, value: value, added: time.Now()}
+		c.lru.MoveToFront(el)
+		return nil
+	}
+
+	c.c[key] = c.lru.PushFront(&entry{key: key, value: value, added: time.Now()})
+	for c.max > 0 && c.lru.Len() > c.max {
+		var el = c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.c, el.Value.(*entry).key)
+		c.evictions++
+	}
+
+	return nil
+}
+
+func (c *rwCache) Stats() Stats {
+	c.RLock()
+	defer c.RUnlock()
+
+	return Stats{c.hits, c.misses, c.evictions, len(c.c)}
+}
+
+func (c *rwCache) Flush() error { return nil }
+func (c *rwCache) Close() error { return nil }
+
+//benchKeys are addresses looked up by the benchmarks, fewer than the caches hold
+var benchKeys = func() []string {
+	var keys = make([]string, 1<<14)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("10.%d.%d.%d", i>>16&255, i>>8&255, i&255)
+	}
+	return keys
+}()
+
+var benchValue = []byte(`{"country":"Germany","city":"Berlin"}`)
+
+//benchStores returns each implementation bounded to max entries
+func benchStores(max int) map[string]Store {
+	var c = New()
+	c.SetLimits(max, 0)
+
+	return map[string]Store{"sharded": c, "rwmutex": newRWCache(max)}
+}
+
+//keysInShard returns n keys that hash to the same shard of c
+func keysInShard(c *Cache, n int) []string {
+	var keys []string
+	var s = c.shard("0")
+	for i := 0; len(keys) < n; i++ {
+		var key = fmt.Sprint(i)
+		if c.shard(key) == s {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+func BenchmarkGetParallel(b *testing.B) {
+	for name, s := range benchStores(1 << 20) {
+		for _, k := range benchKeys {
+			s.Set(k, benchValue)
+		}
+		b.Run(name, func(b *testing.B) {
+			var n uint32
+			b.RunParallel(func(pb *testing.PB) {
+				//each goroutine starts elsewhere in the keys
+				var i = int(atomic.AddUint32(&n, 7919))
+				for pb.Next() {
+					s.Get(benchKeys[i%len(benchKeys)])
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkSetParallel(b *testing.B) {
+	for name, s := range benchStores(1 << 12) {
+		b.Run(name, func(b *testing.B) {
+			var n uint32
+			b.RunParallel(func(pb *testing.PB) {
+				var i = int(atomic.AddUint32(&n, 7919))
+				for pb.Next() {
+					s.Set(benchKeys[i%len(benchKeys)], benchValue)
+					i++
+				}
+			})
+		})
+	}
+}
+
+//BenchmarkLoaderParallel mixes hits and misses as a backfill does, the cache holding a quarter of the addresses
+func BenchmarkLoaderParallel(b *testing.B) {
+	for name, s := range benchStores(len(benchKeys) / 4) {
+		var l = NewLoader(s)
+		var lookup = func() ([]byte, error) { return benchValue, nil }
+		b.Run(name, func(b *testing.B) {
+			var n uint32
+			b.RunParallel(func(pb *testing.PB) {
+				var i = int(atomic.AddUint32(&n, 7919))
+				for pb.Next() {
+					//a skewed pattern, most lookups fall on the first few addresses
+					var k = i % len(benchKeys)
+					if i%4 != 0 {
+						k %= len(benchKeys) / 16
+					}
+					l.Load(benchKeys[k], lookup)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestLRUEviction(t *testing.T) {
+	var c = New()
+	//two entries per shard
+	c.SetLimits(2*shards, 0)
+
+	var keys = keysInShard(c, 3)
+	c.Set(keys[0], []byte("a"))
+	c.Set(keys[1], []byte("b"))
+	//using keys[0] leaves keys[1] least recently used
+	if _, ok, _ := c.Get(keys[0]); !ok {
+		t.Fatalf("%s missing", keys[0])
+	}
+	c.Set(keys[2], []byte("c"))
+
+	if _, ok, _ := c.Get(keys[1]); ok {
+		t.Fatalf("%s was not evicted", keys[1])
+	}
+	for _, k := range []string{keys[0], keys[2]} {
+		if _, ok, _ := c.Get(k); !ok {
+			t.Fatalf("%s was evicted", k)
+		}
+	}
+	if st := c.Stats(); st.Evictions != 1 || st.Len != 2 {
+		t.Fatalf("stats = %+v", st)
+	}
+}
+
+func TestLRUSetLimitsShrinks(t *testing.T) {
+	var c = New()
+	var keys = keysInShard(c, 4)
+	for _, k := range keys {
+		c.Set(k, []byte(k))
+	}
+
+	c.SetLimits(shards, 0)
+	if c.Len() != 1 {
+		t.Fatalf("len = %d, want 1", c.Len())
+	}
+	if _, ok, _ := c.Get(keys[3]); !ok {
+		t.Fatal("the most recently used entry was evicted")
+	}
+	if st := c.Stats(); st.Evictions != 3 {
+		t.Fatalf("evictions = %d, want 3", st.Evictions)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	var c = New()
+	c.SetLimits(0, time.Hour)
+
+	var old = c.shard("old")
+	old.Lock()
+	c.put(old, &entry{key: "old", value: []byte("a"), added: time.Now().Add(-2 * time.Hour)})
+	old.Unlock()
+	c.Set("new", []byte("b"))
+
+	if _, ok, _ := c.Get("old"); ok {
+		t.Fatal("expired entry returned")
+	}
+	if v, ok, _ := c.Get("new"); !ok || string(v) != "b" {
+		t.Fatalf("new = %q, %v", v, ok)
+	}
+	if st := c.Stats(); st.Hits != 1 || st.Misses != 1 || st.Evictions != 1 || st.Len != 1 {
+		t.Fatalf("stats = %+v", st)
+	}
+}
+
+func TestTTLSetLimitsExpires(t *testing.T) {
+	var c = New()
+	var s = c.shard("old")
+	s.Lock()
+	c.put(s, &entry{key: "old", value: []byte("a"), added: time.Now().Add(-2 * time.Hour)})
+	s.Unlock()
+
+	c.SetLimits(0, time.Hour)
+	if c.Len() != 0 {
+		t.Fatalf("len = %d, want 0", c.Len())
+	}
+}
+
+func TestLoaderSingleflight(t *testing.T) {
+	var l = NewLoader(New())
+	var calls int32
+	var release = make(chan struct{})
+	var lookup = func() ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []byte("v"), nil
+	}
+
+	var wg sync.WaitGroup
+	var errs = make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := l.Load("k", lookup)
+			if err == nil && string(v) != "v" {
+				err = fmt.Errorf("got %q", v)
+			}
+			errs <- err
+		}()
+	}
+
+	//let the callers pile up on the first lookup, later ones hit the stored value either way
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("lookup ran %d times, want 1", calls)
+	}
+}
+
+func TestLoaderLookupError(t *testing.T) {
+	var c = New()
+	var l = NewLoader(c)
+	if _, err := l.Load("k", func() ([]byte, error) { return nil, fmt.Errorf("no record") }); err == nil {
+		t.Fatal("lookup error was dropped")
+	}
+	if _, ok, _ := c.Get("k"); ok {
+		t.Fatal("failed lookup was cached")
+	}
+}
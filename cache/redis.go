@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+//Redis is a Store kept on a server speaking the Redis protocol, so several hosts share their lookups.
+//Keys are prefixed with the version, entries of other versions are left to expire.
+type Redis struct {
+	pool   *redis.Pool
+	prefix string
+	ttl    time.Duration
+
+	hits, misses int64
+}
+
+//OpenRedis connects to the server at addr, a host:port or a redis:// URL.
+//Entries expire after ttl, zero keeps them until the server evicts them.
+func OpenRedis(addr, version string, ttl time.Duration) (*Redis, error) {
+	var dial = func() (redis.Conn, error) {
+		return redis.Dial("tcp", addr, redis.DialConnectTimeout(5*time.Second))
+	}
+	if strings.HasPrefix(addr, "redis://") || strings.HasPrefix(addr, "rediss://") {
+		dial = func() (redis.Conn, error) {
+			return redis.DialURL(addr, redis.DialConnectTimeout(5*time.Second))
+		}
+	}
+
+	var r = &Redis{
+		pool:   &redis.Pool{Dial: dial, MaxIdle: 16, IdleTimeout: time.Minute},
+		prefix: "logparser:" + version + ":",
+		ttl:    ttl,
+	}
+
+	//fail now rather than on the first lookup
+	var conn = r.pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		r.pool.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+//Get ...
+func (r *Redis) Get(key string) ([]byte, bool, error) {
+	var conn = r.pool.Get()
+	defer conn.Close()
+
+	v, err := redis.Bytes(conn.Do("GET", r.prefix+key))
+	if err == redis.ErrNil {
+		atomic.AddInt64(&r.misses, 1)
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	atomic.AddInt64(&r.hits, 1)
+	return v, true, nil
+}
+
+//Set ...
+func (r *Redis) Set(key string, value []byte) error {
+	var conn = r.pool.Get()
+	defer conn.Close()
+
+	var err error
+	if r.ttl > 0 {
+		_, err = conn.Do("SET", r.prefix+key, value, "PX", int64(r.ttl/time.Millisecond))
+	} else {
+		_, err = conn.Do("SET", r.prefix+key, value)
+	}
+
+	return err
+}
+
+//Stats ...
+func (r *Redis) Stats() Stats {
+	return Stats{
+		Hits:   atomic.LoadInt64(&r.hits),
+		Misses: atomic.LoadInt64(&r.misses),
+	}
+}
+
+//Flush is a no-op, the server persists on its own
+func (r *Redis) Flush() error {
+	return nil
+}
+
+//Close ...
+func (r *Redis) Close() error {
+	return r.pool.Close()
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"golang.org/x/sync/singleflight"
+)
+
+//Store is a keyed cache of encoded values. Implementations are safe for concurrent use and are
+//opened with a version, entries written under another version are never returned.
+type Store interface {
+	//Get returns the value of key, ok is false when it is missing or expired
+	Get(key string) (value []byte, ok bool, err error)
+	//Set stores value under key, value must not be modified afterwards
+	Set(key string, value []byte) error
+	//Stats returns the lookup counters
+	Stats() Stats
+	//Flush makes what was set durable, where the backend is persistent
+	Flush() error
+	Close() error
+}
+
+//Stats counts cache lookups, evictions include expired entries.
+//Backends that cannot tell leave Evictions and Len at zero.
+type Stats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Len       int
+}
+
+//Loader fills a Store on misses, collapsing concurrent lookups of the same key into one
+type Loader struct {
+	Store
+	group singleflight.Group
+}
+
+//NewLoader ...
+func NewLoader(s Store) *Loader {
+	return &Loader{Store: s}
+}
+
+//Load returns the stored value of key, or calls lookup and stores what it returns.
+//Concurrent callers missing the same key share a single lookup and the same value.
+func (l *Loader) Load(key string, lookup func() ([]byte, error)) ([]byte, error) {
+	v, ok, err := l.Get(key)
+	if err != nil || ok {
+		return v, err
+	}
+
+	res, err, _ := l.group.Do(key, func() (interface{}, error) {
+		v, err := lookup()
+		if err != nil {
+			return nil, err
+		}
+
+		return v, l.Set(key, v)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.([]byte), nil
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+//backend opens Stores that share their data, so a reopened store sees what an earlier one saved
+type backend struct {
+	name string
+	open func(t *testing.T, version string, ttl time.Duration) Store
+	//age lets d pass for the entries of the backend
+	age func(d time.Duration)
+}
+
+func backends(t *testing.T) []backend {
+	var dir = t.TempDir()
+	var mr = miniredis.RunT(t)
+
+	return []backend{
+		{
+			name: "memory",
+			open: func(t *testing.T, version string, ttl time.Duration) Store {
+				c, err := Open(filepath.Join(dir, "cache"), version)
+				if err != nil {
+					t.Fatal(err)
+				}
+				c.SetLimits(0, ttl)
+				return c
+			},
+			age: time.Sleep,
+		},
+		{
+			name: "bolt",
+			open: func(t *testing.T, version string, ttl time.Duration) Store {
+				b, err := OpenBolt(filepath.Join(dir, "cache.db"), version, ttl)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return b
+			},
+			age: time.Sleep,
+		},
+		{
+			name: "redis",
+			open: func(t *testing.T, version string, ttl time.Duration) Store {
+				r, err := OpenRedis(mr.Addr(), version, ttl)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return r
+			},
+			age: mr.FastForward,
+		},
+	}
+}
+
+func get(t *testing.T, s Store, key string) (string, bool) {
+	t.Helper()
+
+	v, ok, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(v), ok
+}
+
+func TestStoreGetSet(t *testing.T) {
+	for _, b := range backends(t) {
+		t.Run(b.name, func(t *testing.T) {
+			var s = b.open(t, "v1", 0)
+			defer s.Close()
+
+			if _, ok := get(t, s, "1.2.3.4"); ok {
+				t.Fatal("empty store returned a value")
+			}
+			if err := s.Set("1.2.3.4", []byte(`{"city":"Berlin"}`)); err != nil {
+				t.Fatal(err)
+			}
+			if v, ok := get(t, s, "1.2.3.4"); !ok || v != `{"city":"Berlin"}` {
+				t.Fatalf("got %q, %v", v, ok)
+			}
+			if err := s.Set("1.2.3.4", []byte(`{"city":"Paris"}`)); err != nil {
+				t.Fatal(err)
+			}
+			if v, _ := get(t, s, "1.2.3.4"); v != `{"city":"Paris"}` {
+				t.Fatalf("overwritten value = %q", v)
+			}
+			if st := s.Stats(); st.Hits != 2 || st.Misses != 1 {
+				t.Fatalf("stats = %+v", st)
+			}
+		})
+	}
+}
+
+func TestStoreVersions(t *testing.T) {
+	for _, b := range backends(t) {
+		t.Run(b.name, func(t *testing.T) {
+			var s = b.open(t, "v1", 0)
+			if err := s.Set("1.2.3.4", []byte("a")); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			s = b.open(t, "v1", 0)
+			if v, ok := get(t, s, "1.2.3.4"); !ok || v != "a" {
+				t.Fatalf("reopened store lost the entry: %q, %v", v, ok)
+			}
+			s.Close()
+
+			s = b.open(t, "v2", 0)
+			defer s.Close()
+			if _, ok := get(t, s, "1.2.3.4"); ok {
+				t.Fatal("entry of another version returned")
+			}
+		})
+	}
+}
+
+func TestStoreTTL(t *testing.T) {
+	for _, b := range backends(t) {
+		t.Run(b.name, func(t *testing.T) {
+			var s = b.open(t, "v1", 50*time.Millisecond)
+			defer s.Close()
+
+			if err := s.Set("old", []byte("a")); err != nil {
+				t.Fatal(err)
+			}
+			b.age(100 * time.Millisecond)
+			if err := s.Set("new", []byte("b")); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := get(t, s, "old"); ok {
+				t.Fatal("expired entry returned")
+			}
+			if v, ok := get(t, s, "new"); !ok || v != "b" {
+				t.Fatalf("new = %q, %v", v, ok)
+			}
+		})
+	}
+}
@@ -1,27 +1,25 @@
 package main
 
 import (
-	"bufio"
-	"compress/gzip"
-	"encoding/csv"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
-	"net"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
-	"strconv"
+	"sort"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/oschwald/geoip2-golang"
 	"github.com/pkg/profile"
-	"github.com/pquerna/ffjson/ffjson"
 
 	"github.com/random9s/Analytics-Pipeline/cache"
-	"github.com/random9s/Analytics-Pipeline/log"
+	"github.com/random9s/Analytics-Pipeline/metrics"
+	"github.com/random9s/Analytics-Pipeline/pipeline"
 )
 
 //Available flags
@@ -31,17 +29,90 @@ var (
 	in       bool
 	cpu, mem bool
 	tuner    int
-
-	readLines, writeLines int64
+	codec    string
+	parallel int
+	follow   bool
+	flushInt time.Duration
+	ckpt     string
+	ckptN    int64
+	mode     string
+	outDir   string
+	nameTmpl string
+	maxRows  int
+	hive     string
+	tz       string
+	partTime string
+	lateness time.Duration
+	schemaF  string
+	prSchema bool
+	unknown  string
+	promoted string
+	drift    string
+	driftErr bool
+	sources  string
+	levels   string
+	prefixTZ string
+	onError  string
+	deadPath string
+	policy   pipeline.Policy
+	stats    string
+	statsF   string
+	progress time.Duration
+	progJSON bool
+	metricsA string
+	geoCity  string
+	geoASN   string
+	geoCache string
+	geoStore string
+	geoEvery time.Duration
+	geoSize  int
+	geoTTL   time.Duration
 )
 
 func parseFlags() {
-	flag.StringVar(&fname, "f", "", "log file name")
+	flag.StringVar(&fname, "f", "", "log file, directory or glob (more may follow as arguments)")
 	flag.BoolVar(&cpu, "cpu", false, "profile cpu (can only run cpu or mem, not both)")
 	flag.BoolVar(&mem, "mem", false, "profile memory (can only run cpu or mem, not both)")
 	flag.BoolVar(&in, "i", false, "read from stdin")
 	flag.BoolVar(&help, "h", false, "print help")
 	flag.IntVar(&tuner, "t", 1, "number will be multiplied by number of logical cores")
+	flag.IntVar(&parallel, "p", runtime.NumCPU(), "number of input files decompressed in parallel")
+	flag.BoolVar(&follow, "follow", false, "keep reading the -f file as it grows, following rotation, until interrupted")
+	flag.DurationVar(&flushInt, "flush", 30*time.Second, "how often output files are flushed in follow mode")
+	flag.StringVar(&ckpt, "checkpoint", "", "checkpoint file to resume from and record progress in")
+	flag.Int64Var(&ckptN, "checkpoint-every", 1000000, "lines read between checkpoints")
+	flag.StringVar(&schemaF, "schema", "", "json file declaring the output columns (default: built in schema)")
+	flag.BoolVar(&prSchema, "print-schema", false, "print the built in schema as json and exit")
+	flag.StringVar(&unknown, "unknown", pipeline.UnknownIgnore, "what to do with event keys and uri parameters no column reads: ignore, extras (json column), promote (new columns) or report")
+	flag.StringVar(&promoted, "promoted-schema", "", "with -unknown promote, write the grown schema to this file")
+	flag.StringVar(&sources, "source", "", "only convert lines whose prefix has one of these comma separated sources, e.g. SDK,API")
+	flag.StringVar(&levels, "level", "", "only convert lines whose prefix has one of these comma separated levels")
+	flag.StringVar(&prefixTZ, "prefix-tz", "UTC", "timezone of the timestamps in line prefixes")
+	flag.StringVar(&onError, "on-error", "", "what to do with lines that cannot be converted, as class=action pairs, e.g. decode=dead-letter,uri=skip,enrich=fail. Classes: decode, uri, enrich, convert. Actions: skip, fail, dead-letter (default: decode=skip, everything else fail)")
+	flag.StringVar(&deadPath, "dead-letter", "", "gzipped json lines file receiving dead-lettered lines (default: rejected.jsonl.gz in the output directory)")
+	flag.StringVar(&stats, "stats", "", "print a report of the run as text or json: rows per partition, client_id and event name, rejections, cache hit rate, bytes and throughput")
+	flag.StringVar(&statsF, "stats-file", "", "also write the json run report to this file, even when the run fails")
+	flag.DurationVar(&progress, "progress", 0, "print progress to stderr at this interval, e.g. 10s (0 disables)")
+	flag.BoolVar(&progJSON, "progress-json", false, "print progress as json lines")
+	flag.StringVar(&metricsA, "metrics", "", "serve Prometheus metrics on this address, e.g. :9100")
+	flag.StringVar(&geoCity, "geoip", "GeoLite2-City.mmdb", "GeoIP2 or GeoLite2 City database")
+	flag.StringVar(&geoASN, "geoip-asn", "", "GeoLite2-ASN database filling the geo.asn and geo.org sources (default: none)")
+	flag.StringVar(&geoStore, "geo-cache-backend", "memory", "where GeoIP lookups are cached: memory (saved to -geo-cache), bolt (a bbolt database at -geo-cache) or redis (a server at -geo-cache, e.g. localhost:6379)")
+	flag.StringVar(&geoCache, "geo-cache", ".cache", "file or redis address of the GeoIP cache, empty keeps a memory cache in memory only")
+	flag.DurationVar(&geoEvery, "geo-cache-every", 5*time.Minute, "how often the GeoIP cache is saved while running (0 saves only at the end)")
+	flag.IntVar(&geoSize, "geo-cache-size", 1000000, "most addresses kept in the GeoIP cache, the least recently used are evicted (0 is unbounded)")
+	flag.DurationVar(&geoTTL, "geo-cache-ttl", 0, "look addresses up again once cached this long, e.g. 720h (0 never expires)")
+	flag.StringVar(&drift, "drift", "", "only compare the input with the schema and print a drift report as text or json, nothing is written")
+	flag.BoolVar(&driftErr, "drift-fail", false, "with -drift, exit with status 2 when the input and the schema disagree")
+	flag.StringVar(&outDir, "out", "", "output directory (default: directory of the first input, or . for stdin)")
+	flag.StringVar(&nameTmpl, "name", pipeline.DefaultTemplate, "output file name template: {date}, {year}, {month}, {day}, {hour}, {seq} or any column, e.g. {client_id}")
+	flag.StringVar(&hive, "partition-by", "", "write hive style key=value directories for these columns instead of using -name, e.g. dt,hour,app=uri_app")
+	flag.IntVar(&maxRows, "max-rows", 0, "start a new file after this many rows, the name template must use {seq}")
+	flag.StringVar(&tz, "tz", "UTC", "timezone timestamps are written in and partitioned by, e.g. UTC or America/Chicago")
+	flag.StringVar(&partTime, "partition-time", pipeline.PartitionRequest, "time rows are partitioned on: request, event or device (event time in the uri_tz timezone)")
+	flag.DurationVar(&lateness, "lateness", 0, "route events sent more than this after they happened to late/, or dated this far ahead to future/ (0 disables)")
+	flag.StringVar(&mode, "mode", pipeline.ModeReplace, "what to do with existing output files: replace or merge")
+	flag.StringVar(&codec, "z", pipeline.CodecAuto, "input compression: auto, none, gzip, zstd, bzip2, xz or lz4")
 	flag.Parse()
 
 	if help {
@@ -49,353 +120,463 @@ func parseFlags() {
 		os.Exit(0)
 	}
 
-	if fname == "" && !in {
+	if prSchema {
+		b, err := json.MarshalIndent(pipeline.DefaultSchema(), "", "  ")
+		exitOnErr(err)
+		fmt.Println(string(b))
+		os.Exit(0)
+	}
+
+	if fname == "" && flag.NArg() == 0 && !in {
 		flag.PrintDefaults()
 		fmt.Println("file name must be provided")
 		os.Exit(1)
 	}
-}
 
-func exitOnErr(err error) {
-	if err != nil {
-		fmt.Println(err)
+	if follow && (fname == "" || flag.NArg() > 0 || in) {
+		fmt.Println("-follow needs exactly one -f file")
 		os.Exit(1)
 	}
-}
 
-//csvfields
-var csvFields = []string{
-	"event_fc",           //0
-	"http_user_agent",    //1
-	"event_ori",          //2
-	"event_uid",          //3
-	"event_ord",          //4
-	"uri_did",            //5
-	"event_lc",           //6
-	"event_lf",           //7
-	"uri_l",              //8
-	"event_dr",           //9
-	"event_sp",           //10
-	"uri_tz",             //11
-	"event_st",           //12
-	"remote_addr",        //13
-	"uri_av",             //14
-	"event_rid",          //15
-	"uri_an",             //16
-	"uri_app",            //17
-	"request_time_float", //18
-	"event_res",          //19
-	"uri_ov",             //20
-	"uri_os",             //21
-	"event_typ",          //22
-	"uri_kv",             //23
-	"event_ct",           //24
-	"uri_sv",             //25
-	"client_id",          //26
-	"request_uri",        //27
-	"event_vs",           //28
-	"event_ps",           //29
-	"event_ts",           //30
-	"event_n",            //31
-	"event_m",            //32
-	"event_tc",           //33
-	"uri_dm",             //34
-	"uri_fv",             //35
-	"event_tg",           //36
-	"event_sn",           //37
-	"uri_q",              //38
-	"uri_uid",            //39
-	"uri_id",             //40
-	"event_sc",           //41
-	"geo_country",        //42
-	"geo_city",           //43
-}
+	if ckpt != "" && (follow || in) {
+		fmt.Println("-checkpoint can only be used with file inputs")
+		os.Exit(1)
+	}
 
-func toString(i interface{}) string {
-	var str string
-	switch i.(type) {
-	case uint, uint8, uint16, uint32, uint64,
-		int, int8, int16, int32, int64:
-		var v = i.(int64)
-		if v != 0 {
-			str = strconv.FormatInt(v, 10)
-		}
-	case float32, float64:
-		var v = i.(float64)
-		if v != 0.0 {
-			str = strconv.FormatFloat(v, 'E', -1, 64)
-		}
+	if hive != "" && nameTmpl != pipeline.DefaultTemplate {
+		fmt.Println("-partition-by and -name cannot be used together")
+		os.Exit(1)
 	}
 
-	return str
-}
+	switch partTime {
+	case pipeline.PartitionRequest, pipeline.PartitionEvent, pipeline.PartitionDevice:
+	default:
+		fmt.Println("-partition-time must be request, event or device")
+		os.Exit(1)
+	}
 
-func handleLog(c *cache.Cache, db *geoip2.Reader, logT *log.Log) []string {
-	var out = make([]string, len(csvFields), len(csvFields))
-
-	//handle event section
-	var e = logT.Event
-	if logT.Event != nil {
-		out[0] = toString(e.Fc)
-		out[2] = e.Ori
-		out[3] = e.UID
-		out[4] = e.Ord
-		out[6] = toString(e.Lc)
-		out[7] = toString(e.Lf)
-		out[9] = toString(e.Dr)
-		out[10] = e.Sp
-		out[12] = e.St
-		out[15] = e.Rid
-		out[19] = toString(e.Resolution)
-		out[22] = toString(e.Type)
-		out[24] = toString(e.Ct)
-		out[28] = e.Vs
-		out[29] = e.Ps
-
-		var res = toString(e.Timestamp)
-		if e.Timestamp > 0 {
-			var t1 = int64(e.Timestamp / 1000)
-			if t1 > 0 {
-				var ut = time.Unix(t1, 0)
-				res = ut.Format("2006-01-02 03:04:05.0")
-			}
-		}
+	if !pipeline.ValidUnknownMode(unknown) {
+		fmt.Println("-unknown must be ignore, extras, promote or report")
+		os.Exit(1)
+	}
 
-		out[30] = res
-		out[31] = e.Name
-		out[32] = e.M
-		out[33] = toString(e.Tc)
-		out[36] = e.Tg
-		out[37] = e.Sn
-		out[41] = e.Sc
+	if geoStore != "memory" && geoStore != "bolt" && geoStore != "redis" {
+		fmt.Println("-geo-cache-backend must be memory, bolt or redis")
+		os.Exit(1)
 	}
 
-	//loop key/value of request parameters
-	reqURI, err := logT.ParseReqURI()
-	exitOnErr(err)
-	for k, v := range reqURI.Query() {
-		var k = "uri_" + strings.ToLower(k)
-		var vStr = strings.Join(v, "+")
+	if geoStore != "memory" && geoCache == "" {
+		fmt.Println("-geo-cache-backend " + geoStore + " needs -geo-cache")
+		os.Exit(1)
+	}
 
-		for i := 0; i < len(csvFields); i++ {
-			if strings.Compare(k, csvFields[i]) == 0 {
-				out[i] = vStr
-			}
+	//only the memory cache is bounded, a size given for another backend would be silently ignored
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "geo-cache-size" && geoStore != "memory" {
+			fmt.Println("-geo-cache-size only applies to -geo-cache-backend memory")
+			os.Exit(1)
 		}
+	})
 
-		switch k {
-		case "uri_d":
-			out[5] = vStr
-		case "uri_dt":
-			out[34] = vStr
-		case "uri_v":
-			out[25] = vStr
-		case "uri_p":
-			out[35] = vStr
-		}
+	if promoted != "" && unknown != pipeline.UnknownPromote {
+		fmt.Println("-promoted-schema needs -unknown promote")
+		os.Exit(1)
 	}
 
-	//Add remainding stuff
-	out[1] = logT.HTTPUserAgent
-	out[13] = logT.RemoteAddr
-	out[18] = logT.ParseRequestTime()
-	out[26] = logT.ClientID
-	out[27] = reqURI.Path
-
-	var cleanIP = strings.Trim(logT.RemoteAddr, "\n")
-	var city, country string
-	city, country, ok := c.Load(cleanIP)
-	if !ok {
-		ip := net.ParseIP(cleanIP)
-		if ip != nil {
-			record, err := db.City(ip)
-			exitOnErr(err)
+	if drift != "" && drift != "text" && drift != "json" {
+		fmt.Println("-drift must be text or json")
+		os.Exit(1)
+	}
 
-			city, ok = record.City.Names["en"]
-			if !ok {
-				city = "nil"
-			}
-			country, ok = record.Country.Names["en"]
-			if !ok {
-				country = "nil"
-			}
+	if stats != "" && stats != "text" && stats != "json" {
+		fmt.Println("-stats must be text or json")
+		os.Exit(1)
+	}
 
-			c.Add(cleanIP, city, country)
-		}
+	if driftErr && drift == "" {
+		fmt.Println("-drift-fail needs -drift")
+		os.Exit(1)
+	}
+
+	if drift != "" && (follow || ckpt != "") {
+		fmt.Println("-drift cannot be used with -follow or -checkpoint")
+		os.Exit(1)
+	}
+
+	var err error
+	if policy, err = pipeline.ParsePolicy(onError); err != nil {
+		fmt.Println("-on-error:", err)
+		os.Exit(1)
 	}
 
-	out[42] = country
-	out[43] = city
-	return out
+	if mode != pipeline.ModeReplace && mode != pipeline.ModeMerge {
+		fmt.Println("-mode must be replace or merge")
+		os.Exit(1)
+	}
 }
 
-type customWriter struct {
-	fp *os.File
-	zw *gzip.Writer
-	w  *csv.Writer
+func exitOnErr(err error) {
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
 
-func fanOut(in chan *string, out chan *[]string, wg *sync.WaitGroup, c *cache.Cache, db *geoip2.Reader) {
-	wg.Add(1)
+func main() {
+	parseFlags()
 
-	go func() {
-		//all log lines start with something similar to this, so we'll just cut this from the beginning of every line
-		var trimN = len("[2017-12-01 20:55:08 ~ SDK ~ 0] ")
+	if cpu {
+		defer profile.Start().Stop()
+	} else if mem {
+		defer profile.Start(profile.MemProfile).Stop()
+	}
 
-		for linePtr := range in {
-			line := *linePtr
+	//create source to read data from
+	var src pipeline.Source
+	var files []string
+	var cp *pipeline.Checkpoint
+	var defaultDir = "."
+	if follow {
+		fs, err := pipeline.NewFollowSource(fname, time.Second)
+		exitOnErr(err)
 
-			//All log lines should begin with the same thing, so we can trim that immediately
-			line = line[trimN:]
+		//stop following on interrupt, letting the pipeline close its files
+		var sig = make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sig
+			fs.Close()
+		}()
 
-			//Always remove newline
-			if line[len(line)-1] == byte(10) {
-				line = line[:len(line)-1]
-			}
+		defaultDir = filepath.Dir(fname)
+		src = fs
+	} else if in {
+		//Create decompressing reader
+		zipReader, err := pipeline.NewDecompressor(os.Stdin, codec)
+		exitOnErr(err)
+		defer zipReader.Close()
 
-			//check if bad value exists and replace with empty string
-			if strings.Contains(line, "\"event\":[]") {
-				line = strings.Replace(line, "\"event\":[]", "", 1)
-			}
+		src = pipeline.NewReaderSource("stdin", zipReader)
+	} else {
+		var args = flag.Args()
+		if fname != "" {
+			args = append([]string{fname}, args...)
+		}
 
-			//unmarshal new log line
-			var logT = new(log.Log)
-			//exitOnErr(ffjson.Unmarshal([]byte(line), logT))
-			err := ffjson.Unmarshal([]byte(line), logT)
-			if err != nil {
-				continue
-			}
+		var err error
+		files, err = pipeline.ExpandInputs(args)
+		exitOnErr(err)
+
+		//outputs go next to the first input unless told otherwise
+		defaultDir = filepath.Dir(files[0])
+	}
+
+	if outDir == "" {
+		outDir = defaultDir
+	}
+
+	var err error
+	var schema = pipeline.DefaultSchema()
+	if schemaF != "" {
+		schema, err = pipeline.LoadSchema(schemaF)
+		exitOnErr(err)
+	}
 
-			//create csv line
-			var csvLine = handleLog(c, db, logT)
-			out <- &csvLine
+	//drift compares the input with the schema as it was given
+	var given = schema
+
+	//extras need a column to go to
+	if unknown == pipeline.UnknownExtras && schema.Index("extras") < 0 {
+		schema, err = pipeline.NewSchema(append(schema.Columns, pipeline.Column{Name: "extras", Source: pipeline.SourceExtras + ".json"}))
+		exitOnErr(err)
+	}
+
+	var namer *pipeline.Template
+	if hive != "" {
+		namer, err = pipeline.ParseHive(hive, schema)
+	} else {
+		namer, err = pipeline.ParseTemplate(nameTmpl, schema)
+	}
+	exitOnErr(err)
+
+	var sink = pipeline.NewFileSink(outDir, namer)
+	if deadPath == "" && policy.Uses(pipeline.ActionDeadLetter) {
+		deadPath = filepath.Join(outDir, "rejected.jsonl.gz")
+	}
+
+	if src == nil {
+		//outputs of earlier runs often sit next to the inputs, they are not read back
+		files = skipOutputs(files, sink, filepath.Join(outDir, "rejected.jsonl.gz"), deadPath)
+		if len(files) == 0 {
+			exitOnErr(fmt.Errorf("no inputs left once earlier outputs are skipped"))
 		}
 
-		wg.Done()
-	}()
-}
+		//resume from the checkpoint when there is one
+		var resume map[string]pipeline.InputState
+		if ckpt != "" {
+			cp, err = pipeline.LoadCheckpoint(ckpt)
+			exitOnErr(err)
+			resume = cp.Inputs()
+		}
 
-func main() {
-	if cpu {
-		defer profile.Start().Stop()
-	} else if mem {
-		defer profile.Start(profile.MemProfile).Stop()
+		src = pipeline.NewFileSource(files, codec, parallel, resume)
 	}
 
-	parseFlags()
+	if drift != "" {
+		runDrift(src, given)
+		return
+	}
 
-	//prep cache
-	c := cache.New()
+	if maxRows > 0 && !namer.Sequenced() {
+		exitOnErr(fmt.Errorf("-max-rows needs {seq} in the -name template"))
+	}
+	if unknown == pipeline.UnknownPromote && !namer.Sequenced() {
+		exitOnErr(fmt.Errorf("-unknown promote needs {seq} in the -name template or -partition-by, a new file is started when columns are added"))
+	}
 
-	//open geoip database
-	db, err := geoip2.Open("GeoLite2-City.mmdb")
+	sink.Mode = mode
+	sink.MaxRows = maxRows
+	//a followed file never ends, so days are published once they go quiet
+	sink.PublishIdle = follow
+
+	//open geoip databases
+	db, err := geoip2.Open(geoCity)
 	exitOnErr(err)
 	defer db.Close()
 
-	//create reader to read data from source
-	var reader *bufio.Reader
-	if fname != "" {
-		//open gzip file
-		fp, err := os.Open(fname)
+	var asn *geoip2.Reader
+	if geoASN != "" {
+		asn, err = geoip2.Open(geoASN)
 		exitOnErr(err)
-		defer fp.Close()
+		defer asn.Close()
+	}
+
+	//prep cache, entries resolved by other database builds are dropped
+	c, err := openCache(pipeline.GeoVersion(db, asn))
+	exitOnErr(err)
+	var stopSaving = saveEvery(c, geoEvery)
+
+	var p = pipeline.New(
+		src,
+		sink,
+		pipeline.NewGeoEnricher(c, db, asn),
+	)
+	p.Decoder = newDecoder()
+	p.Schema = schema
+	p.Workers = runtime.NumCPU() * tuner
+	p.Location, err = time.LoadLocation(tz)
+	exitOnErr(err)
+	p.PartitionBy = partTime
+	p.Lateness = lateness
+	if follow {
+		p.FlushInterval = flushInt
+	}
+	p.Checkpoint = cp
+	p.CheckpointEvery = ckptN
+	p.Policy = policy
+	if p.Policy.Uses(pipeline.ActionDeadLetter) {
+		p.DeadLetter = pipeline.NewDeadLetterFile(deadPath)
+	}
+	p.Unknown = unknown
+	p.ProgressInterval = progress
+	p.ProgressWriter = os.Stderr
+	p.ProgressJSON = progJSON
 
-		//Create gzip reader
-		zipReader, err := gzip.NewReader(fp)
+	if metricsA != "" {
+		srv, err := metrics.Serve(metricsA, p)
 		exitOnErr(err)
-		defer zipReader.Close()
+		defer srv.Close()
+	}
 
-		//wrap zip reader in bufio
-		reader = bufio.NewReader(zipReader)
-	} else if in {
-		zipReader, err := gzip.NewReader(os.Stdin)
+	//the report and the cache are kept even when the run fails
+	var runErr = p.Run()
+	stopSaving()
+	if err := c.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "saving geoip cache: %v\n", err)
+	}
+	if statsF != "" {
+		b, err := json.MarshalIndent(p.Stats(), "", "  ")
 		exitOnErr(err)
-		defer zipReader.Close()
+		exitOnErr(ioutil.WriteFile(statsF, append(b, '\n'), 0644))
+	}
+	exitOnErr(runErr)
 
-		reader = bufio.NewReader(zipReader)
+	switch stats {
+	case "json":
+		b, err := json.MarshalIndent(p.Stats(), "", "  ")
+		exitOnErr(err)
+		fmt.Println(string(b))
+	case "text":
+		exitOnErr(p.Stats().WriteText(os.Stdout))
+	default:
+		readLines, writeLines, _ := p.Counts()
+		fmt.Printf("Read %d lines, wrote %d lines\n", readLines, writeLines)
+		reportCounts("rejected", p.Rejected())
+		if n := p.Filtered(); n > 0 {
+			fmt.Printf("Filtered out %d lines\n", n)
+		}
+		reportCounts("coerced", p.Coercions())
 	}
 
-	//create map for files and close all files on exit
-	var dateFiles = make(map[string]*customWriter)
+	if unknown != pipeline.UnknownIgnore {
+		reportCounts("unknown key", p.UnknownKeys())
+	}
 
-	var in = make(chan *string)
-	var out = make(chan *[]string)
-	var wg = sync.WaitGroup{}
+	if n := sink.Skipped(); n > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d rows of inputs already merged into their files\n", n)
+	}
+	//replace keeps only this run's rows, e.g. one run per hourly file leaves each day holding the last hour
+	if n := sink.Replaced(); n > 0 {
+		fmt.Fprintf(os.Stderr, "replaced %d existing files, -mode merge keeps their rows\n", n)
+	}
 
-	for i := 0; i < runtime.NumCPU()*tuner; i++ {
-		fanOut(in, out, &wg, c, db)
+	if promoted != "" {
+		b, err := json.MarshalIndent(p.Schema, "", "  ")
+		exitOnErr(err)
+		exitOnErr(ioutil.WriteFile(promoted, append(b, '\n'), 0644))
 	}
+}
 
-	var done = make(chan bool)
-	go func() {
-		var i = 0
-
-		for csvLinePtr := range out {
-			var csvLine = *csvLinePtr
-
-			//var t1 = logT.ParseRequestTime()
-			var t1 = csvLine[18] //request time
-			var outfile = fmt.Sprintf("%s/sdk-log-%s.csv.gz", filepath.Dir(fname), strings.Replace(strings.Split(t1, " ")[0], "-", ".", -1))
-
-			cw, exists := dateFiles[outfile]
-			if !exists {
-				//create and wrap file pointer with gzipped csv writer
-				fp, err := os.OpenFile(outfile, os.O_APPEND|os.O_RDWR|os.O_CREATE, 0766)
-				exitOnErr(err)
-				zw := gzip.NewWriter(fp)
-				w := csv.NewWriter(zw)
-				//create new custom writer
-				cw = &customWriter{
-					fp,
-					zw,
-					w,
-				}
-				//store for later use
-				dateFiles[outfile] = cw
-			}
+//skipOutputs drops the files sink writes and the dead-letter files from files, saying so on stderr
+func skipOutputs(files []string, sink *pipeline.FileSink, deadPaths ...string) []string {
+	var dead = make(map[string]bool)
+	for _, d := range deadPaths {
+		if d == "" {
+			continue
+		}
+		d, _ = filepath.Abs(d)
+		dead[d] = true
+		dead[filepath.Join(filepath.Dir(d), "."+filepath.Base(d)+".tmp")] = true
+	}
 
-			exitOnErr(cw.w.Write(csvLine))
-			writeLines++
+	var kept = files[:0]
+	for _, f := range files {
+		var abs, _ = filepath.Abs(f)
+		if sink.Produces(f) || dead[abs] {
+			fmt.Fprintf(os.Stderr, "skipping %s, it is an output\n", f)
+			continue
+		}
+		kept = append(kept, f)
+	}
 
-			//batch records to write to disk every 100k
-			if i%1000000 == 0 && i != 0 {
-				for _, v := range dateFiles {
-					v.w.Flush()
-					exitOnErr(v.w.Error())
-				}
+	return kept
+}
+
+//openCache opens the -geo-cache-backend store, version names the database builds
+func openCache(version string) (cache.Store, error) {
+	switch geoStore {
+	case "memory":
+		var c = cache.New()
+		if geoCache != "" {
+			var err error
+			if c, err = cache.Open(geoCache, version); err != nil {
+				return nil, err
 			}
 		}
+		c.SetLimits(geoSize, geoTTL)
+		return c, nil
+	case "bolt":
+		return cache.OpenBolt(geoCache, version, geoTTL)
+	default:
+		return cache.OpenRedis(geoCache, version, geoTTL)
+	}
+}
 
-		done <- true
-	}()
+//saveEvery flushes c at the given interval until the returned func is called
+func saveEvery(c cache.Store, every time.Duration) func() {
+	if every <= 0 {
+		return func() {}
+	}
+
+	var t = time.NewTicker(every)
+	var done = make(chan struct{})
+	var stopped = make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
 
-	//read until EOF
-	for {
-		//Read and clean json line
-		line, err := reader.ReadString('\n')
-		if err == io.EOF {
-			break
+			if err := c.Flush(); err != nil {
+				fmt.Fprintf(os.Stderr, "saving geoip cache: %v\n", err)
+			}
 		}
-		exitOnErr(err)
+	}()
 
-		in <- &line
-		readLines++
+	return func() {
+		t.Stop()
+		close(done)
+		<-stopped
 	}
+}
+
+//newDecoder applies the prefix flags
+func newDecoder() *pipeline.LogDecoder {
+	var d = new(pipeline.LogDecoder)
+	if sources != "" {
+		d.Sources = strings.Split(sources, ",")
+	}
+	if levels != "" {
+		d.Levels = strings.Split(levels, ",")
+	}
+
+	var err error
+	d.Location, err = time.LoadLocation(prefixTZ)
+	exitOnErr(err)
 
-	close(in)
-	wg.Wait()
+	return d
+}
 
-	close(out)
-	<-done
+//runDrift scans the input without writing anything and prints how it differs from schema
+func runDrift(src pipeline.Source, schema *pipeline.Schema) {
+	var d = pipeline.NewDrift(schema)
+	var p = pipeline.New(src, pipeline.DiscardSink{}, d)
+	p.Decoder = newDecoder()
+	p.Schema = schema
+	p.Workers = runtime.NumCPU() * tuner
+	//nothing is written, so there is nowhere to dead-letter to
+	for class, action := range policy {
+		if action == pipeline.ActionDeadLetter {
+			policy[class] = pipeline.ActionSkip
+		}
+	}
+	p.Policy = policy
+	p.ProgressInterval = progress
+	p.ProgressWriter = os.Stderr
+	p.ProgressJSON = progJSON
+	exitOnErr(p.Run())
+
+	var r = d.Report()
+	if drift == "json" {
+		b, err := json.MarshalIndent(r, "", "  ")
+		exitOnErr(err)
+		fmt.Println(string(b))
+	} else {
+		exitOnErr(r.WriteText(os.Stdout))
+	}
 
-	for _, v := range dateFiles {
-		//final buffer flush
-		v.w.Flush()
-		exitOnErr(v.w.Error())
+	if driftErr && r.Drifted() {
+		os.Exit(2)
+	}
+}
 
-		exitOnErr(v.zw.Close())
-		exitOnErr(v.fp.Close())
+//reportCounts lists counted keys to stderr, most frequent first
+func reportCounts(label string, counts map[string]int64) {
+	var keys = make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
 	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
 
-	fmt.Printf("Read %d lines, wrote %d lines\n", readLines, writeLines)
+	for _, k := range keys {
+		fmt.Fprintf(os.Stderr, "%s %s: %d times\n", label, k, counts[k])
+	}
 }
@@ -1,8 +1,14 @@
 package log
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
 	"net"
 	"net/url"
+	"reflect"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -14,10 +20,110 @@ type Log struct {
 	RemoteAddr    string  `json:"REMOTE_ADDR"`
 	ClientID      string  `json:"CLIENT_ID"`
 	HTTPUserAgent string  `json:"HTTP_USER_AGENT"`
-	Event         *Event  `json:"event"`
+	Events        Events  `json:"event"`
+
+	//Prefix is the header the line was written with, nil if it had none
+	Prefix *Prefix `json:"-"`
+}
+
+//Prefix is the "[2017-12-01 20:55:08 ~ SDK ~ 0] " header in front of a log line
+type Prefix struct {
+	//Time is zero when the timestamp could not be read
+	Time   time.Time
+	Source string
+	Level  string
+}
+
+//PrefixLayout is the layout of the timestamp in a Prefix
+const PrefixLayout = "2006-01-02 15:04:05"
+
+//ParsePrefix splits a line into its prefix and the rest, the timestamp is read in loc.
+//ok is false, and rest the whole line, when the line does not start with a prefix.
+func ParsePrefix(line string, loc *time.Location) (p *Prefix, rest string, ok bool) {
+	if !strings.HasPrefix(line, "[") {
+		return nil, line, false
+	}
+
+	var end = strings.IndexByte(line, ']')
+	if end < 0 {
+		return nil, line, false
+	}
+
+	var parts = strings.Split(line[1:end], "~")
+	if len(parts) != 3 {
+		return nil, line, false
+	}
+
+	p = &Prefix{
+		Source: strings.TrimSpace(parts[1]),
+		Level:  strings.TrimSpace(parts[2]),
+	}
+	if t, err := time.ParseInLocation(PrefixLayout, strings.TrimSpace(parts[0]), loc); err == nil {
+		p.Time = t
+	}
+
+	return p, strings.TrimLeft(line[end+1:], " \t"), true
+}
+
+//Events holds the events of a request, which are sent either as a single object or as an array
+type Events []*Event
+
+//UnmarshalJSON accepts an event object, an array of them or null
+func (es *Events) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if bytes.Equal(b, []byte("null")) {
+		*es = nil
+		return nil
+	}
+
+	if len(b) > 0 && b[0] == '[' {
+		var s = scanner{b: b, i: 1}
+		*es = nil
+		if s.next(']') {
+			return s.end()
+		}
+
+		for {
+			v, err := s.value()
+			if err != nil {
+				return err
+			}
+
+			//null entries carry nothing
+			if string(v) != "null" {
+				var e = new(Event)
+				if err := e.UnmarshalJSON(v); err != nil {
+					return err
+				}
+				*es = append(*es, e)
+			}
+
+			if s.next(',') {
+				continue
+			}
+			if err := s.expect(']'); err != nil {
+				return err
+			}
+			return s.end()
+		}
+	}
+
+	var e = new(Event)
+	if err := e.UnmarshalJSON(b); err != nil {
+		return err
+	}
+	*es = Events{e}
+
+	return nil
+}
+
+//MarshalJSON always writes an array
+func (es Events) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]*Event(es))
 }
 
 //Event ...
+//ffjson: nodecoder
 type Event struct {
 	Name       string `json:"n"`
 	Timestamp  int64  `json:"ts"`
@@ -36,18 +142,51 @@ type Event struct {
 	Lf         int64  `json:"lf"`
 	Sp         string `json:"sp"`
 	St         string `json:"st"`
-	Rid        string `json:rid"`
+	Rid        string `json:"rid"`
 	Resolution int64  `json:"res"`
 	Ori        string `json:"ori"`
 	Ord        string `json:"ord"`
 	Type       int64  `json:"typ"`
+
+	//Extra holds keys that have no field of their own
+	Extra   map[string]interface{} `json:"-"`
+	keys    []string
+	coerced []Coercion
+}
+
+//Coercion records an event value that had to be converted to its field's type
+type Coercion struct {
+	Key string
+	//From is the json type the value was sent as: string, int, float or bool
+	From string
+}
+
+//TimeLayout is how timestamps are written out: ISO-8601, 24-hour clock, milliseconds and zone offset
+const TimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+//Seconds converts fractional epoch seconds to a time with millisecond precision
+func Seconds(f float64) time.Time {
+	return Millis(int64(math.Round(f * 1000)))
+}
+
+//Millis converts epoch milliseconds to a time
+func Millis(ms int64) time.Time {
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+}
+
+//RequestTime converts REQUEST_TIME_FLOAT, in seconds, to a time
+func (l *Log) RequestTime() time.Time {
+	return Seconds(l.ReqTime)
 }
 
-//ParseRequestTime ...
-func (l *Log) ParseRequestTime() string {
-	var t = int64(l.ReqTime / 1.0)
-	var ut = time.Unix(t, 0)
-	return ut.Format("2006-01-02 03:04:05.0")
+//ParseRequestTime formats the request time in loc
+func (l *Log) ParseRequestTime(loc *time.Location) string {
+	return l.RequestTime().In(loc).Format(TimeLayout)
+}
+
+//Time converts the event's millisecond timestamp
+func (e *Event) Time() time.Time {
+	return Millis(e.Timestamp)
 }
 
 //ParseReqURI ...
@@ -64,3 +203,315 @@ func (l *Log) ParseIP() net.IP {
 
 	return net.ParseIP(cleanIP)
 }
+
+//Get returns a top level field by its json key
+func (l *Log) Get(key string) (interface{}, bool) {
+	switch key {
+	case "REQUEST_TIME_FLOAT":
+		return l.ReqTime, true
+	case "REQUEST_URI":
+		return l.ReqURI, true
+	case "REMOTE_ADDR":
+		return l.RemoteAddr, true
+	case "CLIENT_ID":
+		return l.ClientID, true
+	case "HTTP_USER_AGENT":
+		return l.HTTPUserAgent, true
+	}
+
+	return nil, false
+}
+
+//field returns a pointer to the field decoded from key
+func (e *Event) field(key string) interface{} {
+	switch key {
+	case "n":
+		return &e.Name
+	case "ts":
+		return &e.Timestamp
+	case "uid":
+		return &e.UID
+	case "fc":
+		return &e.Fc
+	case "dr":
+		return &e.Dr
+	case "vs":
+		return &e.Vs
+	case "m":
+		return &e.M
+	case "tc":
+		return &e.Tc
+	case "tg":
+		return &e.Tg
+	case "sn":
+		return &e.Sn
+	case "ps":
+		return &e.Ps
+	case "ct":
+		return &e.Ct
+	case "lc":
+		return &e.Lc
+	case "sc":
+		return &e.Sc
+	case "lf":
+		return &e.Lf
+	case "sp":
+		return &e.Sp
+	case "st":
+		return &e.St
+	case "rid":
+		return &e.Rid
+	case "res":
+		return &e.Resolution
+	case "ori":
+		return &e.Ori
+	case "ord":
+		return &e.Ord
+	case "typ":
+		return &e.Type
+	}
+
+	return nil
+}
+
+//Get returns an event value by its json key, from its field or from Extra.
+//A field left at its zero value is only reported when its key was sent.
+func (e *Event) Get(key string) (interface{}, bool) {
+	switch f := e.field(key).(type) {
+	case *string:
+		return *f, *f != "" || e.sent(key)
+	case *int64:
+		return *f, *f != 0 || e.sent(key)
+	}
+
+	v, ok := e.Extra[key]
+	return v, ok
+}
+
+func (e *Event) sent(key string) bool {
+	for _, k := range e.keys {
+		if k == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+//Keys returns the keys the event was decoded from, in the order they were sent
+func (e *Event) Keys() []string {
+	return e.keys
+}
+
+//Coercions lists the values that were sent with another type than their field's
+func (e *Event) Coercions() []Coercion {
+	return e.coerced
+}
+
+//eventKeys interns the json keys of Event's fields, so decoding them allocates nothing
+var eventKeys = func() map[string]string {
+	var keys = make(map[string]string)
+	var t = reflect.TypeOf(Event{})
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("json"); tag != "" && tag != "-" {
+			keys[tag] = tag
+		}
+	}
+	return keys
+}()
+
+//UnmarshalJSON decodes keys with a field of their own into it and keeps the others in Extra.
+//Values of the wrong type are converted when that loses nothing but a fraction, see Coercions.
+func (e *Event) UnmarshalJSON(b []byte) error {
+	var s = scanner{b: b}
+	s.ws()
+	if s.i < len(s.b) && s.b[s.i] == 'n' {
+		if err := s.literal("null"); err != nil {
+			return err
+		}
+		return s.end()
+	}
+
+	if err := s.expect('{'); err != nil {
+		return err
+	}
+	e.keys = make([]string, 0, len(eventKeys))
+	if s.next('}') {
+		return s.end()
+	}
+
+	for {
+		s.ws()
+		var start = s.i
+		if err := s.skipString(); err != nil {
+			return err
+		}
+		var rawKey = s.b[start:s.i]
+		if err := s.expect(':'); err != nil {
+			return err
+		}
+		v, err := s.value()
+		if err != nil {
+			return err
+		}
+
+		if err := e.decode(rawKey, v); err != nil {
+			return err
+		}
+
+		if s.next(',') {
+			continue
+		}
+		if err := s.expect('}'); err != nil {
+			return err
+		}
+		return s.end()
+	}
+}
+
+//decode stores the raw value v of the quoted key rawKey
+func (e *Event) decode(rawKey, v []byte) error {
+	var key string
+	if k, ok := eventKeys[string(rawKey[1:len(rawKey)-1])]; ok {
+		key = k
+	} else {
+		var err error
+		if key, err = str(rawKey); err != nil {
+			return err
+		}
+	}
+	e.keys = append(e.keys, key)
+
+	if f := e.field(key); f != nil {
+		//values of the right type are parsed in place, only the others are coerced
+		switch f := f.(type) {
+		case *int64:
+			if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+				*f = n
+				return nil
+			}
+		case *string:
+			if v[0] == '"' {
+				x, err := str(v)
+				if err != nil {
+					return fmt.Errorf("event key %s: %v", key, err)
+				}
+				*f = x
+				return nil
+			}
+		}
+
+		from, err := coerce(v, f)
+		if err != nil {
+			return fmt.Errorf("event key %s: %v", key, err)
+		}
+		if from != "" {
+			e.coerced = append(e.coerced, Coercion{key, from})
+		}
+		return nil
+	}
+
+	var x interface{}
+	if err := json.Unmarshal(v, &x); err != nil {
+		return err
+	}
+	if e.Extra == nil {
+		e.Extra = make(map[string]interface{})
+	}
+	e.Extra[key] = x
+
+	return nil
+}
+
+//coerce decodes the raw json value b into dst, a *int64 or *string, returning the json type it was
+//converted from or "" when b already had the right type
+func coerce(b []byte, dst interface{}) (string, error) {
+	if len(b) == 0 {
+		return "", errEnd
+	}
+
+	//the value's type follows from its first byte, numbers are kept as text like json.Number
+	var kind string
+	switch c := b[0]; {
+	case c == 'n':
+		return "", nil
+	case c == '"':
+		kind = "string"
+	case c == 't' || c == 'f':
+		kind = "bool"
+	case c == '-' || (c >= '0' && c <= '9'):
+		if _, err := strconv.ParseFloat(string(b), 64); err != nil {
+			return "", fmt.Errorf("invalid number %s", b)
+		}
+		kind = "float"
+		if _, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+			kind = "int"
+		}
+	}
+
+	switch dst := dst.(type) {
+	case *int64:
+		switch kind {
+		case "int":
+			*dst, _ = strconv.ParseInt(string(b), 10, 64)
+			return "", nil
+		case "float":
+			f, _ := strconv.ParseFloat(string(b), 64)
+			if !fitsInt(f) {
+				return "", fmt.Errorf("%s does not fit an integer", b)
+			}
+			*dst = int64(f)
+			return "float", nil
+		case "string":
+			x, err := str(b)
+			if err != nil {
+				return "", err
+			}
+			var s = strings.TrimSpace(x)
+			if s == "" {
+				return "string", nil
+			}
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				*dst = n
+				return "string", nil
+			}
+			if f, err := strconv.ParseFloat(s, 64); err == nil && fitsInt(f) {
+				*dst = int64(f)
+				return "string", nil
+			}
+			return "", fmt.Errorf("%q is not a number", x)
+		case "bool":
+			if b[0] == 't' {
+				*dst = 1
+			}
+			return "bool", nil
+		}
+
+		return "", fmt.Errorf("cannot use %s as an integer", b)
+	case *string:
+		switch kind {
+		case "string":
+			x, err := str(b)
+			if err != nil {
+				return "", err
+			}
+			*dst = x
+			return "", nil
+		case "int", "float":
+			*dst = string(b)
+			return kind, nil
+		case "bool":
+			*dst = string(b)
+			return "bool", nil
+		}
+
+		return "", fmt.Errorf("cannot use %s as a string", b)
+	}
+
+	return "", fmt.Errorf("unsupported field type %T", dst)
+}
+
+//fitsInt reports whether f can be truncated to an int64
+func fitsInt(f float64) bool {
+	return !math.IsNaN(f) && f >= math.MinInt64 && f < math.MaxInt64
+}
@@ -68,7 +68,7 @@ func (j *Event) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
 	fflib.WriteJsonString(buf, string(j.Sp))
 	buf.WriteString(`,"st":`)
 	fflib.WriteJsonString(buf, string(j.St))
-	buf.WriteString(`,"Rid":`)
+	buf.WriteString(`,"rid":`)
 	fflib.WriteJsonString(buf, string(j.Rid))
 	buf.WriteString(`,"res":`)
 	fflib.FormatBits2(buf, uint64(j.Resolution), 10, j.Resolution < 0)
@@ -82,1169 +82,6 @@ func (j *Event) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
 	return nil
 }
 
-const (
-	ffjtEventbase = iota
-	ffjtEventnosuchkey
-
-	ffjtEventName
-
-	ffjtEventTimestamp
-
-	ffjtEventUID
-
-	ffjtEventFc
-
-	ffjtEventDr
-
-	ffjtEventVs
-
-	ffjtEventM
-
-	ffjtEventTc
-
-	ffjtEventTg
-
-	ffjtEventSn
-
-	ffjtEventPs
-
-	ffjtEventCt
-
-	ffjtEventLc
-
-	ffjtEventSc
-
-	ffjtEventLf
-
-	ffjtEventSp
-
-	ffjtEventSt
-
-	ffjtEventRid
-
-	ffjtEventResolution
-
-	ffjtEventOri
-
-	ffjtEventOrd
-
-	ffjtEventType
-)
-
-var ffjKeyEventName = []byte("n")
-
-var ffjKeyEventTimestamp = []byte("ts")
-
-var ffjKeyEventUID = []byte("uid")
-
-var ffjKeyEventFc = []byte("fc")
-
-var ffjKeyEventDr = []byte("dr")
-
-var ffjKeyEventVs = []byte("vs")
-
-var ffjKeyEventM = []byte("m")
-
-var ffjKeyEventTc = []byte("tc")
-
-var ffjKeyEventTg = []byte("tg")
-
-var ffjKeyEventSn = []byte("sn")
-
-var ffjKeyEventPs = []byte("ps")
-
-var ffjKeyEventCt = []byte("ct")
-
-var ffjKeyEventLc = []byte("lc")
-
-var ffjKeyEventSc = []byte("sc")
-
-var ffjKeyEventLf = []byte("lf")
-
-var ffjKeyEventSp = []byte("sp")
-
-var ffjKeyEventSt = []byte("st")
-
-var ffjKeyEventRid = []byte("Rid")
-
-var ffjKeyEventResolution = []byte("res")
-
-var ffjKeyEventOri = []byte("ori")
-
-var ffjKeyEventOrd = []byte("ord")
-
-var ffjKeyEventType = []byte("typ")
-
-// UnmarshalJSON umarshall json - template of ffjson
-func (j *Event) UnmarshalJSON(input []byte) error {
-	fs := fflib.NewFFLexer(input)
-	return j.UnmarshalJSONFFLexer(fs, fflib.FFParse_map_start)
-}
-
-// UnmarshalJSONFFLexer fast json unmarshall - template ffjson
-func (j *Event) UnmarshalJSONFFLexer(fs *fflib.FFLexer, state fflib.FFParseState) error {
-	var err error
-	currentKey := ffjtEventbase
-	_ = currentKey
-	tok := fflib.FFTok_init
-	wantedTok := fflib.FFTok_init
-
-mainparse:
-	for {
-		tok = fs.Scan()
-		//	println(fmt.Sprintf("debug: tok: %v  state: %v", tok, state))
-		if tok == fflib.FFTok_error {
-			goto tokerror
-		}
-
-		switch state {
-
-		case fflib.FFParse_map_start:
-			if tok != fflib.FFTok_left_bracket {
-				wantedTok = fflib.FFTok_left_bracket
-				goto wrongtokenerror
-			}
-			state = fflib.FFParse_want_key
-			continue
-
-		case fflib.FFParse_after_value:
-			if tok == fflib.FFTok_comma {
-				state = fflib.FFParse_want_key
-			} else if tok == fflib.FFTok_right_bracket {
-				goto done
-			} else {
-				wantedTok = fflib.FFTok_comma
-				goto wrongtokenerror
-			}
-
-		case fflib.FFParse_want_key:
-			// json {} ended. goto exit. woo.
-			if tok == fflib.FFTok_right_bracket {
-				goto done
-			}
-			if tok != fflib.FFTok_string {
-				wantedTok = fflib.FFTok_string
-				goto wrongtokenerror
-			}
-
-			kn := fs.Output.Bytes()
-			if len(kn) <= 0 {
-				// "" case. hrm.
-				currentKey = ffjtEventnosuchkey
-				state = fflib.FFParse_want_colon
-				goto mainparse
-			} else {
-				switch kn[0] {
-
-				case 'R':
-
-					if bytes.Equal(ffjKeyEventRid, kn) {
-						currentKey = ffjtEventRid
-						state = fflib.FFParse_want_colon
-						goto mainparse
-					}
-
-				case 'c':
-
-					if bytes.Equal(ffjKeyEventCt, kn) {
-						currentKey = ffjtEventCt
-						state = fflib.FFParse_want_colon
-						goto mainparse
-					}
-
-				case 'd':
-
-					if bytes.Equal(ffjKeyEventDr, kn) {
-						currentKey = ffjtEventDr
-						state = fflib.FFParse_want_colon
-						goto mainparse
-					}
-
-				case 'f':
-
-					if bytes.Equal(ffjKeyEventFc, kn) {
-						currentKey = ffjtEventFc
-						state = fflib.FFParse_want_colon
-						goto mainparse
-					}
-
-				case 'l':
-
-					if bytes.Equal(ffjKeyEventLc, kn) {
-						currentKey = ffjtEventLc
-						state = fflib.FFParse_want_colon
-						goto mainparse
-
-					} else if bytes.Equal(ffjKeyEventLf, kn) {
-						currentKey = ffjtEventLf
-						state = fflib.FFParse_want_colon
-						goto mainparse
-					}
-
-				case 'm':
-
-					if bytes.Equal(ffjKeyEventM, kn) {
-						currentKey = ffjtEventM
-						state = fflib.FFParse_want_colon
-						goto mainparse
-					}
-
-				case 'n':
-
-					if bytes.Equal(ffjKeyEventName, kn) {
-						currentKey = ffjtEventName
-						state = fflib.FFParse_want_colon
-						goto mainparse
-					}
-
-				case 'o':
-
-					if bytes.Equal(ffjKeyEventOri, kn) {
-						currentKey = ffjtEventOri
-						state = fflib.FFParse_want_colon
-						goto mainparse
-
-					} else if bytes.Equal(ffjKeyEventOrd, kn) {
-						currentKey = ffjtEventOrd
-						state = fflib.FFParse_want_colon
-						goto mainparse
-					}
-
-				case 'p':
-
-					if bytes.Equal(ffjKeyEventPs, kn) {
-						currentKey = ffjtEventPs
-						state = fflib.FFParse_want_colon
-						goto mainparse
-					}
-
-				case 'r':
-
-					if bytes.Equal(ffjKeyEventResolution, kn) {
-						currentKey = ffjtEventResolution
-						state = fflib.FFParse_want_colon
-						goto mainparse
-					}
-
-				case 's':
-
-					if bytes.Equal(ffjKeyEventSn, kn) {
-						currentKey = ffjtEventSn
-						state = fflib.FFParse_want_colon
-						goto mainparse
-
-					} else if bytes.Equal(ffjKeyEventSc, kn) {
-						currentKey = ffjtEventSc
-						state = fflib.FFParse_want_colon
-						goto mainparse
-
-					} else if bytes.Equal(ffjKeyEventSp, kn) {
-						currentKey = ffjtEventSp
-						state = fflib.FFParse_want_colon
-						goto mainparse
-
-					} else if bytes.Equal(ffjKeyEventSt, kn) {
-						currentKey = ffjtEventSt
-						state = fflib.FFParse_want_colon
-						goto mainparse
-					}
-
-				case 't':
-
-					if bytes.Equal(ffjKeyEventTimestamp, kn) {
-						currentKey = ffjtEventTimestamp
-						state = fflib.FFParse_want_colon
-						goto mainparse
-
-					} else if bytes.Equal(ffjKeyEventTc, kn) {
-						currentKey = ffjtEventTc
-						state = fflib.FFParse_want_colon
-						goto mainparse
-
-					} else if bytes.Equal(ffjKeyEventTg, kn) {
-						currentKey = ffjtEventTg
-						state = fflib.FFParse_want_colon
-						goto mainparse
-
-					} else if bytes.Equal(ffjKeyEventType, kn) {
-						currentKey = ffjtEventType
-						state = fflib.FFParse_want_colon
-						goto mainparse
-					}
-
-				case 'u':
-
-					if bytes.Equal(ffjKeyEventUID, kn) {
-						currentKey = ffjtEventUID
-						state = fflib.FFParse_want_colon
-						goto mainparse
-					}
-
-				case 'v':
-
-					if bytes.Equal(ffjKeyEventVs, kn) {
-						currentKey = ffjtEventVs
-						state = fflib.FFParse_want_colon
-						goto mainparse
-					}
-
-				}
-
-				if fflib.SimpleLetterEqualFold(ffjKeyEventType, kn) {
-					currentKey = ffjtEventType
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.SimpleLetterEqualFold(ffjKeyEventOrd, kn) {
-					currentKey = ffjtEventOrd
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.SimpleLetterEqualFold(ffjKeyEventOri, kn) {
-					currentKey = ffjtEventOri
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.EqualFoldRight(ffjKeyEventResolution, kn) {
-					currentKey = ffjtEventResolution
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.SimpleLetterEqualFold(ffjKeyEventRid, kn) {
-					currentKey = ffjtEventRid
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.EqualFoldRight(ffjKeyEventSt, kn) {
-					currentKey = ffjtEventSt
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.EqualFoldRight(ffjKeyEventSp, kn) {
-					currentKey = ffjtEventSp
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.SimpleLetterEqualFold(ffjKeyEventLf, kn) {
-					currentKey = ffjtEventLf
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.EqualFoldRight(ffjKeyEventSc, kn) {
-					currentKey = ffjtEventSc
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.SimpleLetterEqualFold(ffjKeyEventLc, kn) {
-					currentKey = ffjtEventLc
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.SimpleLetterEqualFold(ffjKeyEventCt, kn) {
-					currentKey = ffjtEventCt
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.EqualFoldRight(ffjKeyEventPs, kn) {
-					currentKey = ffjtEventPs
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.EqualFoldRight(ffjKeyEventSn, kn) {
-					currentKey = ffjtEventSn
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.SimpleLetterEqualFold(ffjKeyEventTg, kn) {
-					currentKey = ffjtEventTg
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.SimpleLetterEqualFold(ffjKeyEventTc, kn) {
-					currentKey = ffjtEventTc
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.SimpleLetterEqualFold(ffjKeyEventM, kn) {
-					currentKey = ffjtEventM
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.EqualFoldRight(ffjKeyEventVs, kn) {
-					currentKey = ffjtEventVs
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.SimpleLetterEqualFold(ffjKeyEventDr, kn) {
-					currentKey = ffjtEventDr
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.SimpleLetterEqualFold(ffjKeyEventFc, kn) {
-					currentKey = ffjtEventFc
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.SimpleLetterEqualFold(ffjKeyEventUID, kn) {
-					currentKey = ffjtEventUID
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.EqualFoldRight(ffjKeyEventTimestamp, kn) {
-					currentKey = ffjtEventTimestamp
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.SimpleLetterEqualFold(ffjKeyEventName, kn) {
-					currentKey = ffjtEventName
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				currentKey = ffjtEventnosuchkey
-				state = fflib.FFParse_want_colon
-				goto mainparse
-			}
-
-		case fflib.FFParse_want_colon:
-			if tok != fflib.FFTok_colon {
-				wantedTok = fflib.FFTok_colon
-				goto wrongtokenerror
-			}
-			state = fflib.FFParse_want_value
-			continue
-		case fflib.FFParse_want_value:
-
-			if tok == fflib.FFTok_left_brace || tok == fflib.FFTok_left_bracket || tok == fflib.FFTok_integer || tok == fflib.FFTok_double || tok == fflib.FFTok_string || tok == fflib.FFTok_bool || tok == fflib.FFTok_null {
-				switch currentKey {
-
-				case ffjtEventName:
-					goto handle_Name
-
-				case ffjtEventTimestamp:
-					goto handle_Timestamp
-
-				case ffjtEventUID:
-					goto handle_UID
-
-				case ffjtEventFc:
-					goto handle_Fc
-
-				case ffjtEventDr:
-					goto handle_Dr
-
-				case ffjtEventVs:
-					goto handle_Vs
-
-				case ffjtEventM:
-					goto handle_M
-
-				case ffjtEventTc:
-					goto handle_Tc
-
-				case ffjtEventTg:
-					goto handle_Tg
-
-				case ffjtEventSn:
-					goto handle_Sn
-
-				case ffjtEventPs:
-					goto handle_Ps
-
-				case ffjtEventCt:
-					goto handle_Ct
-
-				case ffjtEventLc:
-					goto handle_Lc
-
-				case ffjtEventSc:
-					goto handle_Sc
-
-				case ffjtEventLf:
-					goto handle_Lf
-
-				case ffjtEventSp:
-					goto handle_Sp
-
-				case ffjtEventSt:
-					goto handle_St
-
-				case ffjtEventRid:
-					goto handle_Rid
-
-				case ffjtEventResolution:
-					goto handle_Resolution
-
-				case ffjtEventOri:
-					goto handle_Ori
-
-				case ffjtEventOrd:
-					goto handle_Ord
-
-				case ffjtEventType:
-					goto handle_Type
-
-				case ffjtEventnosuchkey:
-					err = fs.SkipField(tok)
-					if err != nil {
-						return fs.WrapErr(err)
-					}
-					state = fflib.FFParse_after_value
-					goto mainparse
-				}
-			} else {
-				goto wantedvalue
-			}
-		}
-	}
-
-handle_Name:
-
-	/* handler: j.Name type=string kind=string quoted=false*/
-
-	{
-
-		{
-			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
-				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
-			}
-		}
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			outBuf := fs.Output.Bytes()
-
-			j.Name = string(string(outBuf))
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_Timestamp:
-
-	/* handler: j.Timestamp type=int64 kind=int64 quoted=false*/
-
-	{
-		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
-			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int64", tok))
-		}
-	}
-
-	{
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
-
-			if err != nil {
-				return fs.WrapErr(err)
-			}
-
-			j.Timestamp = int64(tval)
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_UID:
-
-	/* handler: j.UID type=string kind=string quoted=false*/
-
-	{
-
-		{
-			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
-				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
-			}
-		}
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			outBuf := fs.Output.Bytes()
-
-			j.UID = string(string(outBuf))
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_Fc:
-
-	/* handler: j.Fc type=int64 kind=int64 quoted=false*/
-
-	{
-		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
-			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int64", tok))
-		}
-	}
-
-	{
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
-
-			if err != nil {
-				return fs.WrapErr(err)
-			}
-
-			j.Fc = int64(tval)
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_Dr:
-
-	/* handler: j.Dr type=int64 kind=int64 quoted=false*/
-
-	{
-		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
-			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int64", tok))
-		}
-	}
-
-	{
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
-
-			if err != nil {
-				return fs.WrapErr(err)
-			}
-
-			j.Dr = int64(tval)
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_Vs:
-
-	/* handler: j.Vs type=string kind=string quoted=false*/
-
-	{
-
-		{
-			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
-				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
-			}
-		}
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			outBuf := fs.Output.Bytes()
-
-			j.Vs = string(string(outBuf))
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_M:
-
-	/* handler: j.M type=string kind=string quoted=false*/
-
-	{
-
-		{
-			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
-				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
-			}
-		}
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			outBuf := fs.Output.Bytes()
-
-			j.M = string(string(outBuf))
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_Tc:
-
-	/* handler: j.Tc type=int64 kind=int64 quoted=false*/
-
-	{
-		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
-			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int64", tok))
-		}
-	}
-
-	{
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
-
-			if err != nil {
-				return fs.WrapErr(err)
-			}
-
-			j.Tc = int64(tval)
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_Tg:
-
-	/* handler: j.Tg type=string kind=string quoted=false*/
-
-	{
-
-		{
-			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
-				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
-			}
-		}
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			outBuf := fs.Output.Bytes()
-
-			j.Tg = string(string(outBuf))
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_Sn:
-
-	/* handler: j.Sn type=string kind=string quoted=false*/
-
-	{
-
-		{
-			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
-				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
-			}
-		}
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			outBuf := fs.Output.Bytes()
-
-			j.Sn = string(string(outBuf))
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_Ps:
-
-	/* handler: j.Ps type=string kind=string quoted=false*/
-
-	{
-
-		{
-			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
-				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
-			}
-		}
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			outBuf := fs.Output.Bytes()
-
-			j.Ps = string(string(outBuf))
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_Ct:
-
-	/* handler: j.Ct type=int64 kind=int64 quoted=false*/
-
-	{
-		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
-			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int64", tok))
-		}
-	}
-
-	{
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
-
-			if err != nil {
-				return fs.WrapErr(err)
-			}
-
-			j.Ct = int64(tval)
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_Lc:
-
-	/* handler: j.Lc type=int64 kind=int64 quoted=false*/
-
-	{
-		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
-			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int64", tok))
-		}
-	}
-
-	{
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
-
-			if err != nil {
-				return fs.WrapErr(err)
-			}
-
-			j.Lc = int64(tval)
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_Sc:
-
-	/* handler: j.Sc type=string kind=string quoted=false*/
-
-	{
-
-		{
-			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
-				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
-			}
-		}
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			outBuf := fs.Output.Bytes()
-
-			j.Sc = string(string(outBuf))
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_Lf:
-
-	/* handler: j.Lf type=int64 kind=int64 quoted=false*/
-
-	{
-		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
-			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int64", tok))
-		}
-	}
-
-	{
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
-
-			if err != nil {
-				return fs.WrapErr(err)
-			}
-
-			j.Lf = int64(tval)
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_Sp:
-
-	/* handler: j.Sp type=string kind=string quoted=false*/
-
-	{
-
-		{
-			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
-				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
-			}
-		}
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			outBuf := fs.Output.Bytes()
-
-			j.Sp = string(string(outBuf))
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_St:
-
-	/* handler: j.St type=string kind=string quoted=false*/
-
-	{
-
-		{
-			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
-				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
-			}
-		}
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			outBuf := fs.Output.Bytes()
-
-			j.St = string(string(outBuf))
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_Rid:
-
-	/* handler: j.Rid type=string kind=string quoted=false*/
-
-	{
-
-		{
-			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
-				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
-			}
-		}
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			outBuf := fs.Output.Bytes()
-
-			j.Rid = string(string(outBuf))
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_Resolution:
-
-	/* handler: j.Resolution type=int64 kind=int64 quoted=false*/
-
-	{
-		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
-			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int64", tok))
-		}
-	}
-
-	{
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
-
-			if err != nil {
-				return fs.WrapErr(err)
-			}
-
-			j.Resolution = int64(tval)
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_Ori:
-
-	/* handler: j.Ori type=string kind=string quoted=false*/
-
-	{
-
-		{
-			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
-				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
-			}
-		}
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			outBuf := fs.Output.Bytes()
-
-			j.Ori = string(string(outBuf))
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_Ord:
-
-	/* handler: j.Ord type=string kind=string quoted=false*/
-
-	{
-
-		{
-			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
-				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
-			}
-		}
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			outBuf := fs.Output.Bytes()
-
-			j.Ord = string(string(outBuf))
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-handle_Type:
-
-	/* handler: j.Type type=int64 kind=int64 quoted=false*/
-
-	{
-		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
-			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int64", tok))
-		}
-	}
-
-	{
-
-		if tok == fflib.FFTok_null {
-
-		} else {
-
-			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
-
-			if err != nil {
-				return fs.WrapErr(err)
-			}
-
-			j.Type = int64(tval)
-
-		}
-	}
-
-	state = fflib.FFParse_after_value
-	goto mainparse
-
-wantedvalue:
-	return fs.WrapErr(fmt.Errorf("wanted value token, but got token: %v", tok))
-wrongtokenerror:
-	return fs.WrapErr(fmt.Errorf("ffjson: wanted token: %v, but got token: %v output=%s", wantedTok, tok, fs.Output.String()))
-tokerror:
-	if fs.BigError != nil {
-		return fs.WrapErr(fs.BigError)
-	}
-	err = fs.Error.ToError()
-	if err != nil {
-		return fs.WrapErr(err)
-	}
-	panic("ffjson-generated: unreachable, please report bug.")
-done:
-
-	return nil
-}
-
 // MarshalJSON marshal bytes to json - template
 func (j *Log) MarshalJSON() ([]byte, error) {
 	var buf fflib.Buffer
@@ -1279,19 +116,16 @@ func (j *Log) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
 	fflib.WriteJsonString(buf, string(j.ClientID))
 	buf.WriteString(`,"HTTP_USER_AGENT":`)
 	fflib.WriteJsonString(buf, string(j.HTTPUserAgent))
-	if j.Event != nil {
-		buf.WriteString(`,"event":`)
-
-		{
+	buf.WriteString(`,"event":`)
 
-			err = j.Event.MarshalJSONBuf(buf)
-			if err != nil {
-				return err
-			}
+	{
 
+		obj, err = j.Events.MarshalJSON()
+		if err != nil {
+			return err
 		}
-	} else {
-		buf.WriteString(`,"event":null`)
+		buf.Write(obj)
+
 	}
 	buf.WriteByte('}')
 	return nil
@@ -1311,7 +145,7 @@ const (
 
 	ffjtLogHTTPUserAgent
 
-	ffjtLogEvent
+	ffjtLogEvents
 )
 
 var ffjKeyLogReqTime = []byte("REQUEST_TIME_FLOAT")
@@ -1324,7 +158,7 @@ var ffjKeyLogClientID = []byte("CLIENT_ID")
 
 var ffjKeyLogHTTPUserAgent = []byte("HTTP_USER_AGENT")
 
-var ffjKeyLogEvent = []byte("event")
+var ffjKeyLogEvents = []byte("event")
 
 // UnmarshalJSON umarshall json - template of ffjson
 func (j *Log) UnmarshalJSON(input []byte) error {
@@ -1423,16 +257,16 @@ mainparse:
 
 				case 'e':
 
-					if bytes.Equal(ffjKeyLogEvent, kn) {
-						currentKey = ffjtLogEvent
+					if bytes.Equal(ffjKeyLogEvents, kn) {
+						currentKey = ffjtLogEvents
 						state = fflib.FFParse_want_colon
 						goto mainparse
 					}
 
 				}
 
-				if fflib.SimpleLetterEqualFold(ffjKeyLogEvent, kn) {
-					currentKey = ffjtLogEvent
+				if fflib.SimpleLetterEqualFold(ffjKeyLogEvents, kn) {
+					currentKey = ffjtLogEvents
 					state = fflib.FFParse_want_colon
 					goto mainparse
 				}
@@ -1499,8 +333,8 @@ mainparse:
 				case ffjtLogHTTPUserAgent:
 					goto handle_HTTPUserAgent
 
-				case ffjtLogEvent:
-					goto handle_Event
+				case ffjtLogEvents:
+					goto handle_Events
 
 				case ffjtLognosuchkey:
 					err = fs.SkipField(tok)
@@ -1650,27 +484,21 @@ handle_HTTPUserAgent:
 	state = fflib.FFParse_after_value
 	goto mainparse
 
-handle_Event:
+handle_Events:
 
-	/* handler: j.Event type=log.Event kind=struct quoted=false*/
+	/* handler: j.Events type=log.Events kind=slice quoted=false*/
 
 	{
-		if tok == fflib.FFTok_null {
-
-			j.Event = nil
-
-		} else {
-
-			if j.Event == nil {
-				j.Event = new(Event)
-			}
+		/* Falling back. type=log.Events kind=slice */
+		tbuf, err := fs.CaptureField(tok)
+		if err != nil {
+			return fs.WrapErr(err)
+		}
 
-			err = j.Event.UnmarshalJSONFFLexer(fs, fflib.FFParse_want_key)
-			if err != nil {
-				return err
-			}
+		err = j.Events.UnmarshalJSON(tbuf)
+		if err != nil {
+			return fs.WrapErr(err)
 		}
-		state = fflib.FFParse_after_value
 	}
 
 	state = fflib.FFParse_after_value
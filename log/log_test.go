@@ -0,0 +1,203 @@
+package log
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+//benchLine is a request with a typical 22 key event
+var benchLine = []byte(`{"REQUEST_TIME_FLOAT":1512161708.123,"REQUEST_URI":"/sdk/v1?d=dev1&app=foo&v=2.1&tz=-5","REMOTE_ADDR":"1.2.3.4","CLIENT_ID":"c1","HTTP_USER_AGENT":"Mozilla/5.0","event":{"n":"open","ts":1512161700000,"uid":"u-123","fc":3,"dr":1200,"vs":"2.1.0","m":"main","tc":4,"tg":"home","sn":"s-1","ps":"p","ct":7,"lc":2,"sc":"sc","lf":9,"sp":"sp","st":"st","rid":"r1","res":1080,"ori":"portrait","ord":"o","typ":1}}`)
+
+func BenchmarkDecodeLog(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var l Log
+		if err := ffjson.Unmarshal(benchLine, &l); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestEventUnmarshal(t *testing.T) {
+	var e Event
+	if err := e.UnmarshalJSON([]byte(` {"n":"open","fc":3,"new":{"a":[1,"x"]},"ok":true, "s":"a\"b"} `)); err != nil {
+		t.Fatal(err)
+	}
+	if e.Name != "open" || e.Fc != 3 {
+		t.Fatalf("fields: %+v", e)
+	}
+	if !reflect.DeepEqual(e.Keys(), []string{"n", "fc", "new", "ok", "s"}) {
+		t.Fatalf("keys: %v", e.Keys())
+	}
+	if !reflect.DeepEqual(e.Extra, map[string]interface{}{
+		"new": map[string]interface{}{"a": []interface{}{1.0, "x"}},
+		"ok":  true,
+		"s":   `a"b`,
+	}) {
+		t.Fatalf("extra: %v", e.Extra)
+	}
+
+	for _, bad := range []string{``, `{`, `{"n"}`, `{"n":}`, `{"n":"a",}`, `{"n":"a"} x`, `{"x":tru}`, `[]`} {
+		if err := new(Event).UnmarshalJSON([]byte(bad)); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
+func TestCoerce(t *testing.T) {
+	var ints = []struct {
+		raw  string
+		want int64
+		from string
+		err  bool
+	}{
+		{raw: `12`, want: 12},
+		{raw: `-3`, want: -3},
+		{raw: `null`},
+		{raw: `"12"`, want: 12, from: "string"},
+		{raw: `" 7 "`, want: 7, from: "string"},
+		{raw: `""`, from: "string"},
+		{raw: `"2.9"`, want: 2, from: "string"},
+		{raw: `12.7`, want: 12, from: "float"},
+		{raw: `1e3`, want: 1000, from: "float"},
+		{raw: `true`, want: 1, from: "bool"},
+		{raw: `false`, from: "bool"},
+		{raw: `1e30`, err: true},
+		{raw: `"abc"`, err: true},
+		{raw: `{}`, err: true},
+		{raw: `[1]`, err: true},
+		{raw: `1-2`, err: true},
+	}
+	for _, c := range ints {
+		var n int64
+		from, err := coerce([]byte(c.raw), &n)
+		if (err != nil) != c.err || n != c.want || from != c.from {
+			t.Errorf("int %s: got %d %q %v", c.raw, n, from, err)
+		}
+	}
+
+	var strs = []struct {
+		raw  string
+		want string
+		from string
+		err  bool
+	}{
+		{raw: `"a,b"`, want: "a,b"},
+		{raw: `"a\nb"`, want: "a\nb"},
+		{raw: `null`},
+		{raw: `5`, want: "5", from: "int"},
+		{raw: `1.50`, want: "1.50", from: "float"},
+		{raw: `true`, want: "true", from: "bool"},
+		{raw: `{"a":1}`, err: true},
+		{raw: `[]`, err: true},
+	}
+	for _, c := range strs {
+		var s string
+		from, err := coerce([]byte(c.raw), &s)
+		if (err != nil) != c.err || s != c.want || from != c.from {
+			t.Errorf("string %s: got %q %q %v", c.raw, s, from, err)
+		}
+	}
+}
+
+func TestEventCoercions(t *testing.T) {
+	var e Event
+	if err := e.UnmarshalJSON([]byte(`{"fc":"3","n":7,"ts":1512161700000.0,"dr":5}`)); err != nil {
+		t.Fatal(err)
+	}
+	if e.Fc != 3 || e.Name != "7" || e.Timestamp != 1512161700000 || e.Dr != 5 {
+		t.Fatalf("fields: %+v", e)
+	}
+	var want = []Coercion{{"fc", "string"}, {"n", "int"}, {"ts", "float"}}
+	if !reflect.DeepEqual(e.Coercions(), want) {
+		t.Fatalf("coercions: %v", e.Coercions())
+	}
+
+	if err := new(Event).UnmarshalJSON([]byte(`{"fc":"abc"}`)); err == nil || !strings.Contains(err.Error(), "event key fc") {
+		t.Fatalf("expected an error naming the key, got %v", err)
+	}
+}
+
+func TestEventsUnmarshal(t *testing.T) {
+	var cases = []struct {
+		raw   string
+		names []string
+	}{
+		{`{"event":{"n":"open"}}`, []string{"open"}},
+		{`{"event":[{"n":"open"},{"n":"close"}]}`, []string{"open", "close"}},
+		{`{"event":[ {"n":"open"} , null, {"n":"close"} ]}`, []string{"open", "close"}},
+		{`{"event":[]}`, nil},
+		{`{"event":null}`, nil},
+		{`{}`, nil},
+	}
+
+	for _, c := range cases {
+		var l Log
+		if err := ffjson.Unmarshal([]byte(c.raw), &l); err != nil {
+			t.Errorf("%s: %v", c.raw, err)
+			continue
+		}
+
+		var names []string
+		for _, e := range l.Events {
+			names = append(names, e.Name)
+		}
+		if !reflect.DeepEqual(names, c.names) {
+			t.Errorf("%s: events %v, want %v", c.raw, names, c.names)
+		}
+	}
+
+	for _, bad := range []string{`[`, `[{"n":"a"}`, `[{"n":"a"},]`, `[{"n":"a"} {"n":"b"}]`, `[1]`, `"open"`} {
+		var es Events
+		if err := es.UnmarshalJSON([]byte(bad)); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
+func TestParsePrefix(t *testing.T) {
+	var est = time.FixedZone("EST", -5*3600)
+	var cases = []struct {
+		line   string
+		prefix *Prefix
+		rest   string
+	}{
+		{
+			line:   `[2017-12-01 20:55:08 ~ SDK ~ 0] {"a":1}`,
+			prefix: &Prefix{Time: time.Date(2017, 12, 1, 20, 55, 8, 0, est), Source: "SDK", Level: "0"},
+			rest:   `{"a":1}`,
+		},
+		{
+			line:   "[2017-12-01 20:55:08~BackendAPI~WARNING]\t{}",
+			prefix: &Prefix{Time: time.Date(2017, 12, 1, 20, 55, 8, 0, est), Source: "BackendAPI", Level: "WARNING"},
+			rest:   `{}`,
+		},
+		{
+			//an unreadable timestamp leaves Time zero but keeps the rest of the prefix
+			line:   `[yesterday ~ SDK ~ 1] {}`,
+			prefix: &Prefix{Source: "SDK", Level: "1"},
+			rest:   `{}`,
+		},
+		{line: `{"a":1}`, rest: `{"a":1}`},
+		{line: `[2017-12-01 20:55:08 ~ SDK] {}`, rest: `[2017-12-01 20:55:08 ~ SDK] {}`},
+		{line: `[2017-12-01 20:55:08 ~ SDK ~ 0 {}`, rest: `[2017-12-01 20:55:08 ~ SDK ~ 0 {}`},
+	}
+
+	for _, c := range cases {
+		p, rest, ok := ParsePrefix(c.line, est)
+		if ok != (c.prefix != nil) || rest != c.rest {
+			t.Errorf("%s: ok %v, rest %q", c.line, ok, rest)
+			continue
+		}
+		if c.prefix == nil {
+			continue
+		}
+		if !p.Time.Equal(c.prefix.Time) || p.Source != c.prefix.Source || p.Level != c.prefix.Level {
+			t.Errorf("%s: got %+v, want %+v", c.line, p, c.prefix)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+//errEnd is returned when the input ends inside a value
+var errEnd = errors.New("unexpected end of json input")
+
+//scanner walks json without decoding it, so values can be decoded straight into their fields
+type scanner struct {
+	b []byte
+	i int
+}
+
+func (s *scanner) ws() {
+	for s.i < len(s.b) {
+		switch s.b[s.i] {
+		case ' ', '\t', '\n', '\r':
+			s.i++
+		default:
+			return
+		}
+	}
+}
+
+//next skips whitespace and consumes c if it comes next
+func (s *scanner) next(c byte) bool {
+	s.ws()
+	if s.i < len(s.b) && s.b[s.i] == c {
+		s.i++
+		return true
+	}
+
+	return false
+}
+
+//expect consumes c or fails
+func (s *scanner) expect(c byte) error {
+	if s.next(c) {
+		return nil
+	}
+	if s.i >= len(s.b) {
+		return errEnd
+	}
+
+	return fmt.Errorf("invalid character %q at offset %d, expected %q", s.b[s.i], s.i, c)
+}
+
+//end fails unless only whitespace is left
+func (s *scanner) end() error {
+	s.ws()
+	if s.i < len(s.b) {
+		return fmt.Errorf("invalid character %q at offset %d after top-level value", s.b[s.i], s.i)
+	}
+
+	return nil
+}
+
+//value skips whitespace and the value that follows, returning its raw bytes
+func (s *scanner) value() ([]byte, error) {
+	s.ws()
+	var start = s.i
+	if err := s.skip(); err != nil {
+		return nil, err
+	}
+
+	return s.b[start:s.i], nil
+}
+
+//skip moves past the value starting at s.i
+func (s *scanner) skip() error {
+	if s.i >= len(s.b) {
+		return errEnd
+	}
+
+	switch c := s.b[s.i]; {
+	case c == '"':
+		return s.skipString()
+	case c == '{':
+		s.i++
+		if s.next('}') {
+			return nil
+		}
+		for {
+			s.ws()
+			if err := s.skipString(); err != nil {
+				return err
+			}
+			if err := s.expect(':'); err != nil {
+				return err
+			}
+			if _, err := s.value(); err != nil {
+				return err
+			}
+			if s.next(',') {
+				continue
+			}
+			return s.expect('}')
+		}
+	case c == '[':
+		s.i++
+		if s.next(']') {
+			return nil
+		}
+		for {
+			if _, err := s.value(); err != nil {
+				return err
+			}
+			if s.next(',') {
+				continue
+			}
+			return s.expect(']')
+		}
+	case c == 't':
+		return s.literal("true")
+	case c == 'f':
+		return s.literal("false")
+	case c == 'n':
+		return s.literal("null")
+	case c == '-' || (c >= '0' && c <= '9'):
+		//numbers are checked when they are parsed
+		for s.i < len(s.b) && numeric(s.b[s.i]) {
+			s.i++
+		}
+		return nil
+	}
+
+	return fmt.Errorf("invalid character %q at offset %d looking for a value", s.b[s.i], s.i)
+}
+
+func numeric(c byte) bool {
+	return (c >= '0' && c <= '9') || c == '-' || c == '+' || c == '.' || c == 'e' || c == 'E'
+}
+
+func (s *scanner) literal(lit string) error {
+	if len(s.b)-s.i < len(lit) || string(s.b[s.i:s.i+len(lit)]) != lit {
+		return fmt.Errorf("invalid literal at offset %d", s.i)
+	}
+	s.i += len(lit)
+
+	return nil
+}
+
+//skipString moves past the quoted string starting at s.i
+func (s *scanner) skipString() error {
+	if s.i >= len(s.b) {
+		return errEnd
+	}
+	if s.b[s.i] != '"' {
+		return fmt.Errorf("invalid character %q at offset %d, expected a string", s.b[s.i], s.i)
+	}
+
+	for s.i++; s.i < len(s.b); s.i++ {
+		switch s.b[s.i] {
+		case '\\':
+			s.i++
+		case '"':
+			s.i++
+			return nil
+		}
+	}
+
+	return errEnd
+}
+
+//str decodes a quoted string, only strings with escapes go through encoding/json
+func str(raw []byte) (string, error) {
+	if len(raw) >= 2 && raw[0] == '"' && raw[len(raw)-1] == '"' && bytes.IndexByte(raw[1:len(raw)-1], '\\') < 0 {
+		return string(raw[1 : len(raw)-1]), nil
+	}
+
+	var v string
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/random9s/Analytics-Pipeline/pipeline"
+)
+
+const namespace = "logparser"
+
+//Collector exposes the counters of a pipeline to Prometheus, they are read when scraped
+type Collector struct {
+	p *pipeline.Pipeline
+
+	linesRead    *prometheus.Desc
+	linesDecoded *prometheus.Desc
+	linesFilter  *prometheus.Desc
+	rejected     *prometheus.Desc
+	rowsWritten  *prometheus.Desc
+	partitionRow *prometheus.Desc
+	bytesRead    *prometheus.Desc
+	geoLookups   *prometheus.Desc
+	geoHits      *prometheus.Desc
+	geoEvict     *prometheus.Desc
+	queueDepth   *prometheus.Desc
+
+	flush prometheus.Histogram
+}
+
+//New creates a Collector for p, it records flush latency through p.OnFlush
+func New(p *pipeline.Pipeline) *Collector {
+	var desc = func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, labels, nil)
+	}
+
+	var c = &Collector{
+		p:            p,
+		linesRead:    desc("lines_read_total", "Lines read from the inputs."),
+		linesDecoded: desc("lines_decoded_total", "Lines decoded into logs."),
+		linesFilter:  desc("lines_filtered_total", "Lines left out by the prefix filters."),
+		rejected:     desc("lines_rejected_total", "Lines rejected, by error class.", "class"),
+		rowsWritten:  desc("rows_written_total", "Rows written to the sink."),
+		partitionRow: desc("partition_rows_total", "Rows written, by output file.", "partition"),
+		bytesRead:    desc("bytes_read_total", "Bytes read, before and after decompression.", "stage"),
+		geoLookups:   desc("geoip_lookups_total", "Addresses looked up by enrichers backed by a cache."),
+		geoHits:      desc("geoip_cache_hits_total", "Addresses found in the cache."),
+		geoEvict:     desc("geoip_cache_evictions_total", "Cache entries evicted for room or expired."),
+		queueDepth:   desc("queue_depth", "Items waiting between the stages.", "queue"),
+		flush: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "flush_duration_seconds",
+			Help:      "Time taken to flush or commit the sink.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		}),
+	}
+
+	var prev = p.OnFlush
+	p.OnFlush = func(d time.Duration) {
+		c.flush.Observe(d.Seconds())
+		if prev != nil {
+			prev(d)
+		}
+	}
+
+	return c
+}
+
+//Describe ...
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.linesRead
+	ch <- c.linesDecoded
+	ch <- c.linesFilter
+	ch <- c.rejected
+	ch <- c.rowsWritten
+	ch <- c.partitionRow
+	ch <- c.bytesRead
+	ch <- c.geoLookups
+	ch <- c.geoHits
+	ch <- c.geoEvict
+	ch <- c.queueDepth
+	c.flush.Describe(ch)
+}
+
+//Collect ...
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	var counter = func(d *prometheus.Desc, v int64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), labels...)
+	}
+
+	read, written, _ := c.p.Counts()
+	counter(c.linesRead, read)
+	counter(c.linesDecoded, c.p.Decoded())
+	counter(c.linesFilter, c.p.Filtered())
+	counter(c.rowsWritten, written)
+
+	for class, n := range c.p.Rejected() {
+		counter(c.rejected, n, class)
+	}
+
+	if pw, ok := c.p.Sink.(pipeline.PartitionWriter); ok {
+		for part, n := range pw.Partitions() {
+			counter(c.partitionRow, n, part)
+		}
+	}
+
+	var pr = c.p.Progress(nil)
+	counter(c.bytesRead, pr.BytesRead, "compressed")
+	counter(c.bytesRead, pr.BytesIn, "decompressed")
+
+	var hits, lookups, evictions int64
+	for _, en := range c.p.Enrichers {
+		if cs, ok := en.(pipeline.CacheStater); ok {
+			var h, m, e = cs.CacheStats()
+			hits += h
+			lookups += h + m
+			evictions += e
+		}
+	}
+	counter(c.geoLookups, lookups)
+	counter(c.geoHits, hits)
+	counter(c.geoEvict, evictions)
+
+	var src, in, out = c.p.QueueDepths()
+	ch <- prometheus.MustNewConstMetric(c.queueDepth, prometheus.GaugeValue, float64(src), "source")
+	ch <- prometheus.MustNewConstMetric(c.queueDepth, prometheus.GaugeValue, float64(in), "workers")
+	ch <- prometheus.MustNewConstMetric(c.queueDepth, prometheus.GaugeValue, float64(out), "writer")
+
+	c.flush.Collect(ch)
+}
+
+//Serve registers a Collector for p and serves /metrics on addr in the background.
+//The listener is opened before returning, so a busy address is reported right away.
+func Serve(addr string, p *pipeline.Pipeline) (*http.Server, error) {
+	var reg = prometheus.NewRegistry()
+	if err := reg.Register(New(p)); err != nil {
+		return nil, err
+	}
+
+	var mux = http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var srv = &http.Server{Addr: addr, Handler: mux}
+	go srv.Serve(ln)
+
+	return srv, nil
+}
@@ -0,0 +1,203 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//checkpoint every million lines unless told otherwise
+const defaultCheckpointEvery = 1000000
+
+//InputState is how far into an input a run has got
+type InputState struct {
+	Offset int64 `json:"offset"`
+	Lines  int64 `json:"lines"`
+}
+
+//Checkpoint is the progress of a run, saved so that an interrupted run can resume without duplicating rows
+type Checkpoint struct {
+	path string
+
+	mu      sync.Mutex
+	inputs  map[string]InputState
+	outputs map[string]int64
+	rejects map[string]int64
+}
+
+type checkpointFile struct {
+	Inputs  map[string]InputState `json:"inputs"`
+	Outputs map[string]int64      `json:"outputs"`
+	Rejects map[string]int64      `json:"rejects,omitempty"`
+}
+
+//LoadCheckpoint reads the checkpoint at path, a missing file gives an empty checkpoint
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	var c = &Checkpoint{
+		path:    path,
+		inputs:  make(map[string]InputState),
+		outputs: make(map[string]int64),
+		rejects: make(map[string]int64),
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f checkpointFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+
+	for k, v := range f.Inputs {
+		c.inputs[k] = v
+	}
+	for k, v := range f.Outputs {
+		c.outputs[k] = v
+	}
+	for k, v := range f.Rejects {
+		c.rejects[k] = v
+	}
+
+	return c, nil
+}
+
+//Inputs returns a copy of the recorded input positions
+func (c *Checkpoint) Inputs() map[string]InputState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var m = make(map[string]InputState, len(c.inputs))
+	for k, v := range c.inputs {
+		m[k] = v
+	}
+
+	return m
+}
+
+//Outputs returns a copy of the committed output file sizes
+func (c *Checkpoint) Outputs() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var m = make(map[string]int64, len(c.outputs))
+	for k, v := range c.outputs {
+		m[k] = v
+	}
+
+	return m
+}
+
+//SetOutputs records committed output file sizes, they are persisted by the next Save
+func (c *Checkpoint) SetOutputs(sizes map[string]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, v := range sizes {
+		c.outputs[k] = v
+	}
+}
+
+//AddOutput records the size an output file had when this run opened it and saves immediately,
+//so that a resumed run can cut the file back even if it crashed before the next Save.
+//It replaces any size recorded earlier, the file was started over.
+func (c *Checkpoint) AddOutput(path string, size int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.outputs[path] = size
+
+	return c.save()
+}
+
+//Rejects returns a copy of the committed dead-letter file sizes
+func (c *Checkpoint) Rejects() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var m = make(map[string]int64, len(c.rejects))
+	for k, v := range c.rejects {
+		m[k] = v
+	}
+
+	return m
+}
+
+//SetRejects records committed dead-letter file sizes, they are persisted by the next Save
+func (c *Checkpoint) SetRejects(sizes map[string]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, v := range sizes {
+		c.rejects[k] = v
+	}
+}
+
+//AddReject is AddOutput for dead-letter files
+func (c *Checkpoint) AddReject(path string, size int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rejects[path] = size
+
+	return c.save()
+}
+
+//Done forgets the output sizes once a run has published its files, the inputs stay recorded as read
+func (c *Checkpoint) Done() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.outputs = make(map[string]int64)
+	c.rejects = make(map[string]int64)
+
+	return c.save()
+}
+
+//Save records the given input positions and writes the checkpoint to disk
+func (c *Checkpoint) Save(inputs map[string]InputState) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, v := range inputs {
+		c.inputs[k] = v
+	}
+
+	return c.save()
+}
+
+//save atomically replaces the checkpoint file, c.mu must be held
+func (c *Checkpoint) save() error {
+	b, err := json.MarshalIndent(checkpointFile{c.inputs, c.outputs, c.rejects}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fp, err := ioutil.TempFile(filepath.Dir(c.path), ".checkpoint")
+	if err != nil {
+		return err
+	}
+
+	if _, err := fp.Write(b); err != nil {
+		fp.Close()
+		os.Remove(fp.Name())
+		return err
+	}
+	if err := fp.Sync(); err != nil {
+		fp.Close()
+		os.Remove(fp.Name())
+		return err
+	}
+	if err := fp.Close(); err != nil {
+		os.Remove(fp.Name())
+		return err
+	}
+
+	return os.Rename(fp.Name(), c.path)
+}
@@ -0,0 +1,121 @@
+package pipeline
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//logLine is a request from device did on 2017-12-01
+func logLine(did string) string {
+	return fmt.Sprintf(`{"REQUEST_TIME_FLOAT":1512161708,"REQUEST_URI":"/sdk/v1?d=%s","REMOTE_ADDR":"1.2.3.4","event":{"n":"open"}}`, did) + "\n"
+}
+
+//badLine cannot be converted, the default policy fails the run on it
+const badLine = `{"REQUEST_TIME_FLOAT":1512161708,"REQUEST_URI":"sdk/v1","event":{"n":"open"}}` + "\n"
+
+func writeFile(t *testing.T, path, text string) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//devices returns the uri_did column of every row of a gzipped csv file
+func devices(t *testing.T, path string) []string {
+	t.Helper()
+
+	var col = DefaultSchema().Index("uri_did")
+	var dids []string
+	for _, rec := range readGzipCSV(t, path) {
+		dids = append(dids, rec[col])
+	}
+
+	return dids
+}
+
+//runCheckpointed converts files into dir, resuming from the checkpoint at ck
+func runCheckpointed(t *testing.T, dir, ck string, files ...string) error {
+	t.Helper()
+
+	cp, err := LoadCheckpoint(ck)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var p = New(NewFileSource(files, "auto", 1, cp.Inputs()), NewFileSink(dir, nil))
+	p.Workers = 1
+	p.Checkpoint = cp
+	p.CheckpointEvery = 1000
+	return p.Run()
+}
+
+func TestCheckpointAfterCompletedRun(t *testing.T) {
+	var dir = t.TempDir()
+	var ck = filepath.Join(dir, "ck.json")
+	var in1, in2 = filepath.Join(dir, "in1.log"), filepath.Join(dir, "in2.log")
+	var out = filepath.Join(dir, "sdk-log-2017.12.01.csv.gz")
+
+	writeFile(t, in1, logLine("a1")+logLine("a2")+logLine("a3"))
+	if err := runCheckpointed(t, dir, ck, in1); err != nil {
+		t.Fatal(err)
+	}
+
+	//a second input fails before the first checkpoint, leaving a short temporary file behind
+	writeFile(t, in2, logLine("b1")+badLine)
+	if err := runCheckpointed(t, dir, ck, in1, in2); err == nil {
+		t.Fatal("expected the bad line to fail the run")
+	}
+
+	writeFile(t, in2, logLine("b1")+logLine("b2"))
+	if err := runCheckpointed(t, dir, ck, in1, in2); err != nil {
+		t.Fatal(err)
+	}
+
+	//in1 was read by the first run, replace leaves the rows of in2
+	if dids := devices(t, out); !reflect.DeepEqual(dids, []string{"b1", "b2"}) {
+		t.Fatalf("got %v", dids)
+	}
+}
+
+func TestCheckpointDoneForgetsOutputs(t *testing.T) {
+	var dir = t.TempDir()
+	var ck = filepath.Join(dir, "ck.json")
+	var in = filepath.Join(dir, "in.log")
+	writeFile(t, in, logLine("a1"))
+
+	if err := runCheckpointed(t, dir, ck, in); err != nil {
+		t.Fatal(err)
+	}
+
+	cp, err := LoadCheckpoint(ck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outs := cp.Outputs(); len(outs) != 0 {
+		t.Fatalf("outputs of a finished run kept: %v", outs)
+	}
+	if st := cp.Inputs()[in]; st.Lines != 1 {
+		t.Fatalf("input state = %+v", st)
+	}
+}
+
+func TestFileSinkRestoreShorterFile(t *testing.T) {
+	var dir = t.TempDir()
+	var tmp = tempPath(filepath.Join(dir, "sdk-log-2017.12.01.csv.gz"))
+	writeFile(t, tmp, "short")
+
+	var s = NewFileSink(dir, nil)
+	err := s.Restore(map[string]int64{tmp: 100})
+	if err == nil || !strings.Contains(err.Error(), "smaller") {
+		t.Fatalf("got %v", err)
+	}
+	if fi, _ := os.Stat(tmp); fi.Size() != 5 {
+		t.Fatalf("file grew to %d bytes", fi.Size())
+	}
+}
@@ -0,0 +1,149 @@
+package pipeline
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/random9s/Analytics-Pipeline/log"
+)
+
+func toString(i interface{}) string {
+	var str string
+	switch i.(type) {
+	case uint, uint8, uint16, uint32, uint64,
+		int, int8, int16, int32, int64:
+		var v = i.(int64)
+		if v != 0 {
+			str = strconv.FormatInt(v, 10)
+		}
+	case float32, float64:
+		var v = i.(float64)
+		if v != 0.0 {
+			str = strconv.FormatFloat(v, 'E', -1, 64)
+		}
+	}
+
+	return str
+}
+
+func (p *Pipeline) location() *time.Location {
+	if p.Location == nil {
+		return time.UTC
+	}
+
+	return p.Location
+}
+
+func (p *Pipeline) schema() *Schema {
+	if p.Schema == nil {
+		p.Schema = DefaultSchema()
+	}
+
+	return p.Schema
+}
+
+//Convert maps a decoded log onto the schema and runs the enrichers over it, giving one row per event.
+//Errors are LineErrors whose class the pipeline's Policy acts on.
+//A log without events still gives one row holding its request columns.
+func (p *Pipeline) Convert(logT *log.Log) ([]*Row, error) {
+	reqURI, err := logT.ParseReqURI()
+	if err != nil {
+		return nil, classify(ClassURI, err)
+	}
+
+	//request parameters are matched case insensitively
+	var query = make(map[string]string)
+	for k, v := range reqURI.Query() {
+		query[strings.ToLower(k)] = strings.Join(v, "+")
+	}
+
+	var events = logT.Events
+	if len(events) == 0 {
+		events = log.Events{nil}
+	}
+
+	var rows = make([]*Row, len(events))
+	for n, ev := range events {
+		var sc = p.currentSchema()
+		var row = &Row{
+			Fields: make([]string, len(sc.Columns)),
+			Log:    logT,
+			Event:  ev,
+			Index:  n,
+			schema: sc,
+		}
+
+		for i := range sc.Columns {
+			var c = &sc.Columns[i]
+			for _, k := range c.keys {
+				if v, ok := lookup(logT, row, reqURI, query, c.ns, k); ok {
+					row.Fields[i] = c.format(v, p.location())
+					break
+				}
+			}
+		}
+
+		if p.Unknown != "" && p.Unknown != UnknownIgnore {
+			if err := p.handleUnknown(logT, reqURI, query, row); err != nil {
+				return nil, err
+			}
+			sc = row.schema
+		}
+
+		p.partitionTime(logT, row, query["tz"])
+
+		for _, en := range p.Enrichers {
+			if err := en.Enrich(logT, row); err != nil {
+				return nil, classify(ClassEnrich, err)
+			}
+		}
+
+		for i, c := range sc.Columns {
+			if row.Fields[i] == "" {
+				row.Fields[i] = c.Default
+			}
+		}
+
+		rows[n] = row
+	}
+
+	return rows, nil
+}
+
+func lookup(logT *log.Log, row *Row, reqURI *url.URL, query map[string]string, ns, key string) (interface{}, bool) {
+	switch ns {
+	case SourceEvent:
+		if row.Event != nil {
+			return row.Event.Get(key)
+		}
+	case SourceURI:
+		v, ok := query[key]
+		return v, ok
+	case SourceLog:
+		return logT.Get(key)
+	case SourcePrefix:
+		if logT.Prefix != nil {
+			switch key {
+			case "time":
+				return logT.Prefix.Time, true
+			case "source":
+				return logT.Prefix.Source, true
+			case "level":
+				return logT.Prefix.Level, true
+			}
+		}
+	case SourceRequest:
+		switch key {
+		case "path":
+			return reqURI.Path, true
+		case "event_index":
+			if row.Event != nil {
+				return strconv.Itoa(row.Index), true
+			}
+		}
+	}
+
+	return nil, false
+}
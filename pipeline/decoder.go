@@ -0,0 +1,83 @@
+package pipeline
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/pquerna/ffjson/ffjson"
+
+	"github.com/random9s/Analytics-Pipeline/log"
+)
+
+//ErrShortLine is returned for lines holding no json
+var ErrShortLine = errors.New("line too short")
+
+//ErrFiltered is returned for lines left out by the decoder's filters, they are counted apart from bad lines
+var ErrFiltered = errors.New("line filtered out")
+
+//LogDecoder decodes SDK log lines. Lines usually start with a "[2017-12-01 20:55:08 ~ SDK ~ 0] " prefix,
+//which is parsed into log.Prefix, lines without it are decoded as plain json.
+type LogDecoder struct {
+	//Location is the timezone of prefix timestamps, defaults to UTC
+	Location *time.Location
+	//Sources and Levels, when set, keep only lines whose prefix has one of them, matched case insensitively.
+	//Lines without a prefix are then left out too.
+	Sources []string
+	Levels  []string
+}
+
+//Decode parses the log prefix and unmarshals the remaining json
+func (d *LogDecoder) Decode(l *Line) (*log.Log, error) {
+	//Always remove newline
+	var line = strings.TrimRight(l.Text, "\r\n")
+
+	var loc = d.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	prefix, line, _ := log.ParsePrefix(line, loc)
+	if !d.keep(prefix) {
+		return nil, ErrFiltered
+	}
+
+	if len(line) == 0 {
+		return nil, ErrShortLine
+	}
+
+	//unmarshal new log line
+	var logT = new(log.Log)
+	if err := ffjson.Unmarshal([]byte(line), logT); err != nil {
+		return nil, err
+	}
+	logT.Prefix = prefix
+
+	return logT, nil
+}
+
+func (d *LogDecoder) keep(p *log.Prefix) bool {
+	if len(d.Sources) == 0 && len(d.Levels) == 0 {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+
+	return matchAny(d.Sources, p.Source) && matchAny(d.Levels, p.Level)
+}
+
+//matchAny reports whether v is in list, an empty list matches everything
+func matchAny(list []string, v string) bool {
+	if len(list) == 0 {
+		return true
+	}
+
+	for _, s := range list {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+
+	return false
+}
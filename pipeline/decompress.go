@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+//Codecs understood by NewDecompressor
+const (
+	CodecAuto  = "auto"
+	CodecNone  = "none"
+	CodecGzip  = "gzip"
+	CodecZstd  = "zstd"
+	CodecBzip2 = "bzip2"
+	CodecXz    = "xz"
+	CodecLz4   = "lz4"
+)
+
+//magic bytes at the start of each compressed format
+var magics = []struct {
+	codec string
+	magic []byte
+}{
+	{CodecGzip, []byte{0x1f, 0x8b}},
+	{CodecZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{CodecBzip2, []byte("BZh")},
+	{CodecXz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{CodecLz4, []byte{0x04, 0x22, 0x4d, 0x18}},
+}
+
+//DetectCodec sniffs the magic bytes at the head of r without consuming them
+func DetectCodec(r *bufio.Reader) (string, error) {
+	head, err := r.Peek(6)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	for _, m := range magics {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.codec, nil
+		}
+	}
+
+	return CodecNone, nil
+}
+
+//NewDecompressor wraps r in the reader for codec, CodecAuto picks one from the stream's magic bytes
+func NewDecompressor(r io.Reader, codec string) (io.ReadCloser, error) {
+	var br = bufio.NewReader(r)
+	if codec == "" || codec == CodecAuto {
+		var err error
+		codec, err = DetectCodec(br)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch codec {
+	case CodecNone:
+		return ioutil.NopCloser(br), nil
+	case CodecGzip:
+		return gzip.NewReader(br)
+	case CodecZstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case CodecBzip2:
+		return ioutil.NopCloser(bzip2.NewReader(br)), nil
+	case CodecXz:
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(xr), nil
+	case CodecLz4:
+		return ioutil.NopCloser(lz4.NewReader(br)), nil
+	}
+
+	return nil, fmt.Errorf("unknown codec %q", codec)
+}
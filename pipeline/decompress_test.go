@@ -0,0 +1,103 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+const plain = "hello\n"
+
+//bzip2Hello is plain compressed by bzip2, the standard library only reads the format
+var bzip2Hello = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xc1, 0xc0, 0x80, 0xe2, 0x00, 0x00,
+	0x01, 0x41, 0x00, 0x00, 0x10, 0x02, 0x44, 0xa0, 0x00, 0x30, 0xcd, 0x00, 0xc3, 0x46, 0x29, 0x97,
+	0x17, 0x72, 0x45, 0x38, 0x50, 0x90, 0xc1, 0xc0, 0x80, 0xe2,
+}
+
+//compress writes plain through the writer newW wraps around a buffer
+func compress(t *testing.T, newW func(w io.Writer) (io.WriteCloser, error)) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := newW(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestNewDecompressor(t *testing.T) {
+	var gz = compress(t, func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil })
+	var zst = compress(t, func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) })
+	var xzb = compress(t, func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) })
+	var lz = compress(t, func(w io.Writer) (io.WriteCloser, error) { return lz4.NewWriter(w), nil })
+
+	for _, tc := range []struct {
+		name   string
+		in     []byte
+		codec  string
+		detect string
+		want   string
+		err    bool
+	}{
+		{name: "gzip", in: gz, codec: CodecAuto, detect: CodecGzip, want: plain},
+		{name: "zstd", in: zst, codec: CodecAuto, detect: CodecZstd, want: plain},
+		{name: "bzip2", in: bzip2Hello, codec: CodecAuto, detect: CodecBzip2, want: plain},
+		{name: "xz", in: xzb, codec: CodecAuto, detect: CodecXz, want: plain},
+		{name: "lz4", in: lz, codec: CodecAuto, detect: CodecLz4, want: plain},
+		{name: "plain", in: []byte(plain), codec: CodecAuto, detect: CodecNone, want: plain},
+		{name: "empty codec is auto", in: gz, codec: "", detect: CodecGzip, want: plain},
+		{name: "empty", in: nil, codec: CodecAuto, detect: CodecNone, want: ""},
+		{name: "shorter than a magic", in: []byte{0x1f}, codec: CodecAuto, detect: CodecNone, want: "\x1f"},
+		{name: "shorter than the peek", in: []byte("BZh9"), codec: CodecAuto, detect: CodecBzip2, err: true},
+		{name: "forced", in: gz, codec: CodecGzip, detect: CodecGzip, want: plain},
+		{name: "forced none", in: []byte(plain), codec: CodecNone, detect: CodecNone, want: plain},
+		{name: "forced on plain text", in: []byte(plain), codec: CodecGzip, detect: CodecNone, err: true},
+		{name: "unknown", in: gz, codec: "rar", detect: CodecGzip, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			codec, err := DetectCodec(bufio.NewReader(bytes.NewReader(tc.in)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if codec != tc.detect {
+				t.Errorf("detected %q, want %q", codec, tc.detect)
+			}
+
+			//a bad stream may only show once it is read
+			r, err := NewDecompressor(bytes.NewReader(tc.in), tc.codec)
+			var got []byte
+			if err == nil {
+				got, err = ioutil.ReadAll(r)
+				r.Close()
+			}
+			if tc.err {
+				if err == nil {
+					t.Fatalf("read %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Fatalf("read %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/random9s/Analytics-Pipeline/log"
+)
+
+//Types a value can be inferred as besides the column types
+const (
+	TypeBool   = "bool"
+	TypeObject = "object"
+	TypeArray  = "array"
+	TypeNull   = "null"
+)
+
+//maxExamples is the number of distinct example values kept per key
+const maxExamples = 3
+
+//KeyStats describes one event key or request parameter seen in the input
+type KeyStats struct {
+	//Key is namespace.key, e.g. event.fc or uri.app
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+	//Types counts the values of each inferred type
+	Types    map[string]int64 `json:"types"`
+	Examples []string         `json:"examples"`
+	//Columns lists the schema columns reading the key
+	Columns []string `json:"columns,omitempty"`
+}
+
+//Type is the most frequent inferred type
+func (k *KeyStats) Type() string {
+	var best string
+	for t, n := range k.Types {
+		if n > k.Types[best] || (n == k.Types[best] && t < best) {
+			best = t
+		}
+	}
+
+	return best
+}
+
+//DriftReport compares the keys seen in the input with a schema
+type DriftReport struct {
+	Logs int64       `json:"logs"`
+	Keys []*KeyStats `json:"keys"`
+	//Unmapped keys are read by no column
+	Unmapped []string `json:"unmapped"`
+	//Unseen columns read event keys or request parameters that never appeared
+	Unseen []string `json:"unseen"`
+	//Mismatched columns saw values their type cannot hold
+	Mismatched []string `json:"mismatched"`
+}
+
+//Drifted reports whether the input has keys no column reads or values a column cannot hold.
+//Unseen columns are left out, the built in schema has columns for keys only some SDKs send.
+func (r *DriftReport) Drifted() bool {
+	return len(r.Unmapped) > 0 || len(r.Mismatched) > 0
+}
+
+//WriteText writes the report in a human readable form
+func (r *DriftReport) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d logs scanned\n\n", r.Logs)
+	fmt.Fprintf(&b, "%-24s %10s  %-8s %-20s %s\n", "KEY", "COUNT", "TYPE", "COLUMNS", "EXAMPLES")
+	for _, k := range r.Keys {
+		var cols = strings.Join(k.Columns, ",")
+		if cols == "" {
+			cols = "-"
+		}
+		fmt.Fprintf(&b, "%-24s %10d  %-8s %-20s %s\n", k.Key, k.Count, k.Type(), cols, strings.Join(k.Examples, " | "))
+	}
+
+	var section = func(title string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n%s:\n", title)
+		for _, i := range items {
+			fmt.Fprintf(&b, "  %s\n", i)
+		}
+	}
+	section("Keys no column reads", r.Unmapped)
+	section("Columns whose keys never appeared", r.Unseen)
+	section("Columns with values of another type", r.Mismatched)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+//Drift is an Enricher that records every event key and request parameter it sees
+//so that the input can be compared with a schema, it leaves rows untouched
+type Drift struct {
+	Schema *Schema
+
+	mu   sync.Mutex
+	logs int64
+	keys map[string]*KeyStats
+}
+
+//NewDrift creates a Drift comparing the input with schema
+func NewDrift(schema *Schema) *Drift {
+	return &Drift{
+		Schema: schema,
+		keys:   make(map[string]*KeyStats),
+	}
+}
+
+//Enrich ...
+func (d *Drift) Enrich(l *log.Log, row *Row) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	//request parameters are seen once per log, whatever its number of events
+	if row.Index == 0 {
+		reqURI, err := l.ParseReqURI()
+		if err != nil {
+			return err
+		}
+
+		d.logs++
+		for k, v := range reqURI.Query() {
+			var s = strings.Join(v, "+")
+			d.observe(SourceURI+"."+strings.ToLower(k), s, inferString(s))
+		}
+	}
+
+	if row.Event != nil {
+		//coerced values count as the type they were sent as
+		var sent = make(map[string]string)
+		for _, c := range row.Event.Coercions() {
+			sent[c.Key] = c.From
+		}
+
+		for _, k := range row.Event.Keys() {
+			var v, _ = row.Event.Get(k)
+			var typ, ok = sent[k]
+			if !ok {
+				typ = inferType(v)
+			}
+			d.observe(SourceEvent+"."+k, v, typ)
+		}
+	}
+
+	return nil
+}
+
+func (d *Drift) observe(key string, v interface{}, typ string) {
+	ks, ok := d.keys[key]
+	if !ok {
+		ks = &KeyStats{Key: key, Types: make(map[string]int64)}
+		d.keys[key] = ks
+	}
+
+	ks.Count++
+	ks.Types[typ]++
+
+	if len(ks.Examples) < maxExamples && typ != TypeNull {
+		var ex = example(v)
+		for _, e := range ks.Examples {
+			if e == ex {
+				return
+			}
+		}
+		ks.Examples = append(ks.Examples, ex)
+	}
+}
+
+//Report compares what was seen so far with the schema
+func (d *Drift) Report() *DriftReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var sc = d.Schema
+	if sc == nil {
+		sc = DefaultSchema()
+	}
+
+	//columns reading each key
+	var cols = make(map[string][]int)
+	for i, c := range sc.Columns {
+		if c.ns != SourceEvent && c.ns != SourceURI {
+			continue
+		}
+		for _, k := range c.keys {
+			cols[c.ns+"."+k] = append(cols[c.ns+"."+k], i)
+		}
+	}
+
+	var r = &DriftReport{Logs: d.logs}
+	for key, ks := range d.keys {
+		var cp = *ks
+		cp.Types = make(map[string]int64, len(ks.Types))
+		for t, n := range ks.Types {
+			cp.Types[t] = n
+		}
+		cp.Examples = append([]string(nil), ks.Examples...)
+		for _, i := range cols[key] {
+			cp.Columns = append(cp.Columns, sc.Columns[i].Name)
+		}
+		if len(cp.Columns) == 0 {
+			r.Unmapped = append(r.Unmapped, key)
+		}
+		r.Keys = append(r.Keys, &cp)
+	}
+
+	for i, c := range sc.Columns {
+		if c.ns != SourceEvent && c.ns != SourceURI {
+			continue
+		}
+
+		var seen bool
+		var types = make(map[string]int64)
+		for _, k := range c.keys {
+			if ks, ok := d.keys[c.ns+"."+k]; ok {
+				seen = true
+				for t, n := range ks.Types {
+					types[t] += n
+				}
+			}
+		}
+		if !seen {
+			r.Unseen = append(r.Unseen, sc.Columns[i].Name)
+			continue
+		}
+
+		var bad []string
+		for t := range types {
+			if !typeFits(c.Type, t) {
+				bad = append(bad, t)
+			}
+		}
+		if len(bad) > 0 {
+			sort.Strings(bad)
+			r.Mismatched = append(r.Mismatched, fmt.Sprintf("%s: %s column saw %s", c.Name, c.Type, strings.Join(bad, ", ")))
+		}
+	}
+
+	sort.Slice(r.Keys, func(i, j int) bool {
+		if r.Keys[i].Count != r.Keys[j].Count {
+			return r.Keys[i].Count > r.Keys[j].Count
+		}
+		return r.Keys[i].Key < r.Keys[j].Key
+	})
+	sort.Strings(r.Unmapped)
+
+	return r
+}
+
+//example writes a value the way it appeared in the input
+func example(v interface{}) string {
+	switch x := v.(type) {
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+
+	return (&Column{Type: TypeString}).format(v, nil)
+}
+
+//inferType names the type of a decoded json value
+func inferType(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return TypeNull
+	case bool:
+		return TypeBool
+	case int64:
+		return TypeInt
+	case float64:
+		if x == math.Trunc(x) && math.Abs(x) < 1<<53 {
+			return TypeInt
+		}
+		return TypeFloat
+	case string:
+		return TypeString
+	case []interface{}:
+		return TypeArray
+	}
+
+	return TypeObject
+}
+
+//inferString guesses the type of a request parameter, which is always a string
+func inferString(s string) string {
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return TypeInt
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return TypeFloat
+	}
+	if _, err := strconv.ParseBool(s); err == nil {
+		return TypeBool
+	}
+
+	return TypeString
+}
+
+//typeFits reports whether a column of type col can hold values inferred as typ
+func typeFits(col, typ string) bool {
+	switch typ {
+	case TypeNull:
+		return true
+	case TypeObject, TypeArray:
+		return col == TypeString
+	}
+
+	switch col {
+	case TypeInt, TypeTimeMS:
+		return typ == TypeInt
+	case TypeFloat, TypeTimeS:
+		return typ == TypeInt || typ == TypeFloat
+	}
+
+	return true
+}
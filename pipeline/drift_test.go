@@ -0,0 +1,43 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+)
+
+//driftReport runs a Drift over lines with the default schema
+func driftReport(t *testing.T, lines string) *DriftReport {
+	t.Helper()
+
+	var d = NewDrift(DefaultSchema())
+	var p = New(NewReaderSource("test", strings.NewReader(lines)), DiscardSink{}, d)
+	p.Workers = 1
+	if err := p.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	return d.Report()
+}
+
+func TestDriftUnseenOnly(t *testing.T) {
+	//most built in columns never appear here
+	var r = driftReport(t, `{"REQUEST_URI":"/sdk/v1?d=dev1","event":{"n":"open","fc":3}}`+"\n")
+	if len(r.Unseen) == 0 {
+		t.Fatal("no unseen columns reported")
+	}
+	if r.Drifted() {
+		t.Fatalf("unseen columns alone count as drift: unmapped %v, mismatched %v", r.Unmapped, r.Mismatched)
+	}
+}
+
+func TestDriftUnmappedAndMismatched(t *testing.T) {
+	var r = driftReport(t, `{"REQUEST_URI":"/sdk/v1?d=dev1","event":{"n":"open","brand_new":1}}`+"\n")
+	if !r.Drifted() || len(r.Unmapped) == 0 {
+		t.Fatalf("unmapped key not drift: %v", r.Unmapped)
+	}
+
+	r = driftReport(t, `{"REQUEST_URI":"/sdk/v1?d=dev1","event":{"n":"open","fc":"12"}}`+"\n")
+	if !r.Drifted() || len(r.Mismatched) == 0 {
+		t.Fatalf("mismatched value not drift: %v", r.Mismatched)
+	}
+}
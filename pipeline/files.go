@@ -0,0 +1,229 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//ExpandInputs resolves files, directories and glob patterns into a sorted list of files
+func ExpandInputs(args []string) ([]string, error) {
+	var seen = make(map[string]bool)
+	var files []string
+	var add = func(f string) {
+		if !seen[f] {
+			seen[f] = true
+			files = append(files, f)
+		}
+	}
+
+	for _, arg := range args {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no input matches %s", arg)
+		}
+
+		for _, m := range matches {
+			fi, err := os.Stat(m)
+			if err != nil {
+				return nil, err
+			}
+
+			if !fi.IsDir() {
+				add(m)
+				continue
+			}
+
+			//directories contribute every visible regular file they hold
+			entries, err := ioutil.ReadDir(m)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if e.Mode().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+					add(filepath.Join(m, e.Name()))
+				}
+			}
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+//FileSource reads lines from several files, decompressing up to Parallel of them at once
+type FileSource struct {
+	lines chan *Line
+	quit  chan struct{}
+	once  sync.Once
+
+	mu  sync.Mutex
+	err error
+
+	bytesRead, bytesTotal int64
+}
+
+//NewFileSource starts reading files, codec is passed to NewDecompressor for each of them.
+//Inputs found in resume are read from the recorded position onwards.
+func NewFileSource(files []string, codec string, parallel int, resume map[string]InputState) *FileSource {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	var s = &FileSource{
+		lines: make(chan *Line, parallel),
+		quit:  make(chan struct{}),
+	}
+
+	//files that cannot be stat'ed fail once they are opened
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			s.bytesTotal += fi.Size()
+		}
+	}
+
+	go func() {
+		var sem = make(chan struct{}, parallel)
+		var wg = sync.WaitGroup{}
+
+		for _, f := range files {
+			select {
+			case sem <- struct{}{}:
+			case <-s.quit:
+			}
+			if s.stopped() {
+				break
+			}
+
+			wg.Add(1)
+			go func(f string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				if err := s.readFile(f, codec, resume[f]); err != nil {
+					s.fail(fmt.Errorf("%s: %v", f, err))
+				}
+			}(f)
+		}
+
+		wg.Wait()
+		close(s.lines)
+	}()
+
+	return s
+}
+
+func (s *FileSource) readFile(name, codec string, from InputState) error {
+	fp, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	zr, err := NewDecompressor(&countReader{fp, &s.bytesRead}, codec)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	var src = NewReaderSource(name, zr)
+	if err := src.Skip(from); err != nil {
+		return err
+	}
+
+	for {
+		line, err := src.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		select {
+		case s.lines <- line:
+		case <-s.quit:
+			return nil
+		}
+	}
+}
+
+func (s *FileSource) stopped() bool {
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *FileSource) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+
+	s.Close()
+}
+
+//Next returns lines from all files in the order they are read
+func (s *FileSource) Next() (*Line, error) {
+	line, ok := <-s.lines
+	if ok && !s.stopped() {
+		return line, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	return nil, io.EOF
+}
+
+//BytesRead returns the number of bytes read from the files so far, before decompression
+func (s *FileSource) BytesRead() int64 {
+	return atomic.LoadInt64(&s.bytesRead)
+}
+
+//Queued returns the number of lines read ahead
+func (s *FileSource) Queued() int {
+	return len(s.lines)
+}
+
+//BytesTotal returns the combined size of the files
+func (s *FileSource) BytesTotal() int64 {
+	return s.bytesTotal
+}
+
+//countReader adds the number of bytes read to n
+type countReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+//Close stops reading any remaining files
+func (s *FileSource) Close() error {
+	s.once.Do(func() {
+		close(s.quit)
+	})
+
+	return nil
+}
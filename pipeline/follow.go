@@ -0,0 +1,168 @@
+package pipeline
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+//FollowSource tails a growing file, picking up rename and truncate rotation, until closed
+type FollowSource struct {
+	Path string
+	//Poll is how long to wait for new data once the end of the file is reached
+	Poll time.Duration
+
+	fp       *os.File
+	r        *bufio.Reader
+	offset   int64
+	partial  string
+	num      int64
+	rotating bool
+
+	quit chan struct{}
+	once sync.Once
+}
+
+//NewFollowSource opens path and starts reading from its beginning
+func NewFollowSource(path string, poll time.Duration) (*FollowSource, error) {
+	var s = &FollowSource{
+		Path: path,
+		Poll: poll,
+		quit: make(chan struct{}),
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FollowSource) open() error {
+	fp, err := os.Open(s.Path)
+	if err != nil {
+		return err
+	}
+
+	if s.fp != nil {
+		s.fp.Close()
+	}
+
+	s.fp = fp
+	s.r = bufio.NewReader(fp)
+	s.offset = 0
+	s.rotating = false
+	return nil
+}
+
+//Next blocks until a complete line is available or the source is closed
+func (s *FollowSource) Next() (*Line, error) {
+	for {
+		//a file that keeps growing may never reach EOF
+		select {
+		case <-s.quit:
+			s.fp.Close()
+			return nil, io.EOF
+		default:
+		}
+
+		chunk, err := s.r.ReadString('\n')
+		s.offset += int64(len(chunk))
+		s.partial += chunk
+
+		if err == nil {
+			return s.line(), nil
+		}
+		if err != io.EOF {
+			return nil, err
+		}
+
+		if s.rotating {
+			//the old file is drained, move on to its replacement
+			if err := s.open(); err != nil {
+				return nil, err
+			}
+
+			//whatever was left unterminated in the old file is all there will be
+			if s.partial != "" {
+				return s.line(), nil
+			}
+			continue
+		}
+
+		rotated, truncated, err := s.check()
+		if err != nil {
+			return nil, err
+		}
+
+		if truncated {
+			if _, err := s.fp.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			s.r.Reset(s.fp)
+			s.offset = 0
+			s.partial = ""
+			continue
+		}
+
+		if rotated {
+			//read once more from the old file in case it grew before it was moved
+			s.rotating = true
+			continue
+		}
+
+		select {
+		case <-s.quit:
+			s.fp.Close()
+			return nil, io.EOF
+		case <-time.After(s.Poll):
+		}
+	}
+}
+
+func (s *FollowSource) line() *Line {
+	s.num++
+	var l = &Line{
+		Text:   s.partial,
+		Input:  s.Path,
+		Num:    s.num,
+		Offset: s.offset,
+	}
+	s.partial = ""
+
+	return l
+}
+
+//check reports whether Path now names a different file or has shrunk below what was read
+func (s *FollowSource) check() (rotated, truncated bool, err error) {
+	fi, err := os.Stat(s.Path)
+	if os.IsNotExist(err) {
+		//moved away but not yet recreated
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	cur, err := s.fp.Stat()
+	if err != nil {
+		return false, false, err
+	}
+
+	if !os.SameFile(fi, cur) {
+		return true, false, nil
+	}
+
+	return false, fi.Size() < s.offset, nil
+}
+
+//Close stops following, a blocked Next returns io.EOF
+func (s *FollowSource) Close() error {
+	s.once.Do(func() {
+		close(s.quit)
+	})
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package pipeline
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//nextLines reads n lines from s, failing if they do not arrive in time
+func nextLines(t *testing.T, s *FollowSource, n int) []string {
+	t.Helper()
+
+	var texts = make(chan string)
+	var errs = make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			line, err := s.Next()
+			if err != nil {
+				errs <- err
+				return
+			}
+			texts <- line.Text
+		}
+	}()
+
+	var got []string
+	for len(got) < n {
+		select {
+		case text := <-texts:
+			got = append(got, text)
+		case err := <-errs:
+			t.Fatal(err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after %q", got)
+		}
+	}
+
+	return got
+}
+
+func appendFile(t *testing.T, path, text string) {
+	t.Helper()
+
+	fp, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+
+	if _, err := fp.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectLines(t *testing.T, got []string, want ...string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestFollowRename(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "sdk.log")
+	appendFile(t, path, "a\nb\n")
+
+	s, err := NewFollowSource(path, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	expectLines(t, nextLines(t, s, 2), "a\n", "b\n")
+
+	//the old file grows and ends unterminated before it is moved, both are read before the new file
+	appendFile(t, path, "c\nd")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "e\n")
+
+	expectLines(t, nextLines(t, s, 3), "c\n", "d", "e\n")
+}
+
+func TestFollowTruncate(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "sdk.log")
+	appendFile(t, path, "first\nsecond\n")
+
+	s, err := NewFollowSource(path, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	expectLines(t, nextLines(t, s, 2), "first\n", "second\n")
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "x\n")
+
+	expectLines(t, nextLines(t, s, 1), "x\n")
+}
+
+func TestFollowClose(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "sdk.log")
+	appendFile(t, path, "")
+
+	s, err := NewFollowSource(path, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var done = make(chan error, 1)
+	go func() {
+		_, err := s.Next()
+		done <- err
+	}()
+	s.Close()
+
+	select {
+	case err := <-done:
+		if err != io.EOF {
+			t.Fatalf("got %v, want io.EOF", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Next did not return after Close")
+	}
+}
+
+func TestFollowCloseWhileBehind(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "sdk.log")
+	for i := 0; i < 100; i++ {
+		appendFile(t, path, "line\n")
+	}
+
+	s, err := NewFollowSource(path, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, nextLines(t, s, 1), "line\n")
+
+	//lines are still waiting to be read, Close stops the source anyway
+	s.Close()
+	if _, err := s.Next(); err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,151 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+
+	"github.com/random9s/Analytics-Pipeline/cache"
+	"github.com/random9s/Analytics-Pipeline/log"
+)
+
+//geoFormat is bumped whenever geoRecord changes, so cached records of the old shape are looked up again
+const geoFormat = "geo2"
+
+//GeoEnricher fills the geo sources from the remote address: country, city, country_iso, subdivision,
+//subdivision_iso, postal, latitude, longitude, accuracy_radius, continent and timezone from the City
+//database, and asn and org from the optional ASN database. Unknown values are left empty.
+type GeoEnricher struct {
+	Cache *cache.Loader
+	DB    *geoip2.Reader
+	ASN   *geoip2.Reader
+}
+
+//geoRecord is what GeoEnricher caches for an address, values are formatted as they are written
+type geoRecord struct {
+	Country        string `json:"country,omitempty"`
+	City           string `json:"city,omitempty"`
+	CountryISO     string `json:"country_iso,omitempty"`
+	Subdivision    string `json:"subdivision,omitempty"`
+	SubdivisionISO string `json:"subdivision_iso,omitempty"`
+	Postal         string `json:"postal,omitempty"`
+	Latitude       string `json:"latitude,omitempty"`
+	Longitude      string `json:"longitude,omitempty"`
+	AccuracyRadius string `json:"accuracy_radius,omitempty"`
+	Continent      string `json:"continent,omitempty"`
+	TimeZone       string `json:"timezone,omitempty"`
+	ASN            string `json:"asn,omitempty"`
+	Org            string `json:"org,omitempty"`
+}
+
+//NewGeoEnricher caches lookups in c, which should be opened with the GeoVersion of the databases.
+//asn may be nil.
+func NewGeoEnricher(c cache.Store, db, asn *geoip2.Reader) *GeoEnricher {
+	return &GeoEnricher{Cache: cache.NewLoader(c), DB: db, ASN: asn}
+}
+
+//GeoVersion names the database builds and record format, a cache opened with it drops records looked up otherwise
+func GeoVersion(db, asn *geoip2.Reader) string {
+	var md = db.Metadata()
+	var v = fmt.Sprintf("%s:%s-%d", geoFormat, md.DatabaseType, md.BuildEpoch)
+	if asn != nil {
+		md = asn.Metadata()
+		v += fmt.Sprintf("+%s-%d", md.DatabaseType, md.BuildEpoch)
+	}
+
+	return v
+}
+
+//CacheStats returns the number of addresses found in and missing from the cache, and of entries evicted
+func (g *GeoEnricher) CacheStats() (hits, misses, evictions int64) {
+	var st = g.Cache.Stats()
+	return st.Hits, st.Misses, st.Evictions
+}
+
+//Enrich ...
+func (g *GeoEnricher) Enrich(l *log.Log, row *Row) error {
+	var cleanIP = strings.Trim(l.RemoteAddr, "\n")
+	ip := net.ParseIP(cleanIP)
+	if ip == nil {
+		return nil
+	}
+
+	//workers missing the same address wait for one database lookup
+	b, err := g.Cache.Load(cleanIP, func() ([]byte, error) {
+		rec, err := g.lookup(ip)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(rec)
+	})
+	if err != nil {
+		return err
+	}
+
+	var rec geoRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return err
+	}
+
+	row.Set("geo.country", rec.Country)
+	row.Set("geo.city", rec.City)
+	row.Set("geo.country_iso", rec.CountryISO)
+	row.Set("geo.subdivision", rec.Subdivision)
+	row.Set("geo.subdivision_iso", rec.SubdivisionISO)
+	row.Set("geo.postal", rec.Postal)
+	row.Set("geo.latitude", rec.Latitude)
+	row.Set("geo.longitude", rec.Longitude)
+	row.Set("geo.accuracy_radius", rec.AccuracyRadius)
+	row.Set("geo.continent", rec.Continent)
+	row.Set("geo.timezone", rec.TimeZone)
+	row.Set("geo.asn", rec.ASN)
+	row.Set("geo.org", rec.Org)
+	return nil
+}
+
+//lookup reads ip from the databases
+func (g *GeoEnricher) lookup(ip net.IP) (*geoRecord, error) {
+	record, err := g.DB.City(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	var rec = &geoRecord{
+		Country:    record.Country.Names["en"],
+		City:       record.City.Names["en"],
+		CountryISO: record.Country.IsoCode,
+		Postal:     record.Postal.Code,
+		Continent:  record.Continent.Code,
+		TimeZone:   record.Location.TimeZone,
+	}
+
+	//the first subdivision is the largest, e.g. a state rather than a county
+	if len(record.Subdivisions) > 0 {
+		rec.Subdivision = record.Subdivisions[0].Names["en"]
+		rec.SubdivisionISO = record.Subdivisions[0].IsoCode
+	}
+
+	//a location is always decoded, a zero radius means the database has none for ip
+	if record.Location.AccuracyRadius > 0 {
+		rec.Latitude = strconv.FormatFloat(record.Location.Latitude, 'f', -1, 64)
+		rec.Longitude = strconv.FormatFloat(record.Location.Longitude, 'f', -1, 64)
+		rec.AccuracyRadius = strconv.Itoa(int(record.Location.AccuracyRadius))
+	}
+
+	if g.ASN != nil {
+		asn, err := g.ASN.ASN(ip)
+		if err != nil {
+			return nil, err
+		}
+		if asn.AutonomousSystemNumber > 0 {
+			rec.ASN = strconv.FormatUint(uint64(asn.AutonomousSystemNumber), 10)
+		}
+		rec.Org = asn.AutonomousSystemOrganization
+	}
+
+	return rec, nil
+}
@@ -0,0 +1,215 @@
+package pipeline
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+//DefaultTemplate is the file name used when none is configured
+const DefaultTemplate = "sdk-log-{date}.csv.gz"
+
+//Namer decides which file, relative to the sink's directory, a row is written to.
+//seq numbers the files a partition is split into once they reach the sink's MaxRows.
+type Namer interface {
+	Name(row *Row, seq int) string
+	//Sequenced reports whether names depend on seq
+	Sequenced() bool
+}
+
+type tmplPart struct {
+	lit string
+	key string
+	col int
+}
+
+//Template names files by replacing placeholders in a pattern: {date} (2006.01.02), {dt} (2006-01-02),
+//{year}, {month}, {day}, {hour}, {seq} and the name of any column in the schema, e.g. {client_id} or {uri_app}.
+//The pattern may contain slashes to write into subdirectories.
+type Template struct {
+	parts  []tmplPart
+	hasSeq bool
+	//escape turns column values into path elements
+	escape func(string) string
+	//re matches every name the template gives
+	re *regexp.Regexp
+}
+
+//ParseTemplate resolves column placeholders against schema
+func ParseTemplate(pattern string, schema *Schema) (*Template, error) {
+	var t = &Template{escape: pathValue}
+	for pattern != "" {
+		var open = strings.IndexByte(pattern, '{')
+		if open < 0 {
+			t.parts = append(t.parts, tmplPart{lit: pattern})
+			break
+		}
+
+		var end = strings.IndexByte(pattern[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated placeholder in %q", pattern)
+		}
+
+		if open > 0 {
+			t.parts = append(t.parts, tmplPart{lit: pattern[:open]})
+		}
+
+		var key = pattern[open+1 : open+end]
+		var part = tmplPart{key: key, col: -1}
+		switch key {
+		case "date", "dt", "year", "month", "day", "hour":
+		case "seq":
+			t.hasSeq = true
+		default:
+			part.col = schema.Index(key)
+			if part.col < 0 {
+				return nil, fmt.Errorf("unknown placeholder {%s}", key)
+			}
+		}
+
+		t.parts = append(t.parts, part)
+		pattern = pattern[open+end+1:]
+	}
+
+	var re = []string{"^"}
+	for _, p := range t.parts {
+		switch {
+		case p.key == "":
+			re = append(re, regexp.QuoteMeta(p.lit))
+		case p.col >= 0:
+			//both escapes keep values within one path element
+			re = append(re, "[^/]+")
+		case p.key == "seq":
+			re = append(re, `\d{4,}`)
+		case p.key == "date":
+			re = append(re, `\d{4}\.\d{2}\.\d{2}`)
+		case p.key == "dt":
+			re = append(re, `\d{4}-\d{2}-\d{2}`)
+		case p.key == "year":
+			re = append(re, `\d{4}`)
+		default:
+			re = append(re, `\d{2}`)
+		}
+	}
+	t.re = regexp.MustCompile(strings.Join(append(re, "$"), ""))
+
+	return t, nil
+}
+
+//Name ...
+func (t *Template) Name(row *Row, seq int) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		switch {
+		case p.key == "":
+			b.WriteString(p.lit)
+		case p.col >= 0:
+			b.WriteString(t.escape(row.Fields[p.col]))
+		case p.key == "seq":
+			fmt.Fprintf(&b, "%04d", seq)
+		case p.key == "date":
+			b.WriteString(row.Time.Format("2006.01.02"))
+		case p.key == "dt":
+			b.WriteString(row.Time.Format("2006-01-02"))
+		case p.key == "year":
+			b.WriteString(row.Time.Format("2006"))
+		case p.key == "month":
+			b.WriteString(row.Time.Format("01"))
+		case p.key == "day":
+			b.WriteString(row.Time.Format("02"))
+		case p.key == "hour":
+			b.WriteString(row.Time.Format("15"))
+		}
+	}
+
+	return b.String()
+}
+
+//Sequenced ...
+func (t *Template) Sequenced() bool {
+	return t.hasSeq
+}
+
+//Matches reports whether the template could give name, a slash separated path
+func (t *Template) Matches(name string) bool {
+	return t.re.MatchString(name)
+}
+
+//pathValue makes a column value safe to use as a single path element
+func pathValue(v string) string {
+	if v == "" {
+		return "unknown"
+	}
+
+	v = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', 0:
+			return '_'
+		}
+		return r
+	}, v)
+
+	if strings.HasPrefix(v, ".") {
+		v = "_" + v
+	}
+
+	return v
+}
+
+//HiveDefault is the partition value Hive uses for empty columns
+const HiveDefault = "__HIVE_DEFAULT_PARTITION__"
+
+//ParseHive builds a Template laying files out in key=value directories.
+//spec is a comma separated list of partition columns, each either a placeholder name (dt, hour, uri_app, ...)
+//or directory=placeholder to rename it, so "dt,hour,app=uri_app" gives dt=2017-12-01/hour=20/app=foo/part-0000.csv.gz
+func ParseHive(spec string, schema *Schema) (*Template, error) {
+	var pattern []string
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		var dir, key = p, p
+		if i := strings.IndexByte(p, '='); i >= 0 {
+			dir, key = p[:i], p[i+1:]
+		}
+		if key == "seq" {
+			return nil, fmt.Errorf("cannot partition by seq")
+		}
+
+		pattern = append(pattern, dir+"={"+key+"}")
+	}
+
+	if len(pattern) == 0 {
+		return nil, fmt.Errorf("no partition columns in %q", spec)
+	}
+	pattern = append(pattern, "part-{seq}.csv.gz")
+
+	t, err := ParseTemplate(strings.Join(pattern, "/"), schema)
+	if err != nil {
+		return nil, err
+	}
+	t.escape = hiveValue
+
+	return t, nil
+}
+
+//hiveValue escapes a partition value the way Hive does
+func hiveValue(v string) string {
+	if v == "" {
+		return HiveDefault
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		var c = v[i]
+		if c < 0x20 || c == 0x7f || strings.IndexByte("\"#%'*/:=?\\{[]^", c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,60 @@
+package pipeline
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestTemplateMatches(t *testing.T) {
+	var schema = DefaultSchema()
+	var cases = []struct {
+		pattern string
+		hive    bool
+		name    string
+		want    bool
+	}{
+		{DefaultTemplate, false, "sdk-log-2017.12.01.csv.gz", true},
+		{DefaultTemplate, false, "sdk-log-2017-12-01.csv.gz", false},
+		{DefaultTemplate, false, "sdk.log.gz", false},
+		{"{uri_app}/{date}/{hour}-{seq}.csv.gz", false, "foo/2017.12.01/20-0003.csv.gz", true},
+		{"{uri_app}/{date}/{hour}-{seq}.csv.gz", false, "foo/bar/2017.12.01/20-0003.csv.gz", false},
+		{"dt,app=uri_app", true, "dt=2017-12-01/app=foo/part-0000.csv.gz", true},
+		{"dt,app=uri_app", true, "dt=2017-12-01/part-0000.csv.gz", false},
+	}
+
+	for _, c := range cases {
+		var tmpl *Template
+		var err error
+		if c.hive {
+			tmpl, err = ParseHive(c.pattern, schema)
+		} else {
+			tmpl, err = ParseTemplate(c.pattern, schema)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := tmpl.Matches(c.name); got != c.want {
+			t.Errorf("%s matches %s = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+func TestFileSinkProduces(t *testing.T) {
+	var dir = t.TempDir()
+	var s = NewFileSink(dir, nil)
+
+	for path, want := range map[string]bool{
+		filepath.Join(dir, "sdk-log-2017.12.01.csv.gz"):          true,
+		filepath.Join(dir, ".sdk-log-2017.12.01.csv.gz.tmp"):     true,
+		filepath.Join(dir, ".sdk-log-2017.12.01.csv.gz.inputs"):  true,
+		filepath.Join(dir, "late", "sdk-log-2017.12.01.csv.gz"):  true,
+		filepath.Join(dir, "sdk.log.gz"):                         false,
+		filepath.Join(dir, "..", "sdk-log-2017.12.01.csv.gz"):    false,
+		filepath.Join(dir, "other", "sdk-log-2017.12.01.csv.gz"): false,
+	} {
+		if got := s.Produces(path); got != want {
+			t.Errorf("Produces(%s) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package pipeline
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/random9s/Analytics-Pipeline/log"
+)
+
+//Times rows can be partitioned on
+const (
+	//PartitionRequest is when the log line was received
+	PartitionRequest = "request"
+	//PartitionEvent is the event's own timestamp
+	PartitionEvent = "event"
+	//PartitionDevice is the event's timestamp in the device's timezone taken from uri_tz
+	PartitionDevice = "device"
+)
+
+//Buckets rows outside the lateness window are routed to
+const (
+	BucketLate   = "late"
+	BucketFuture = "future"
+)
+
+//partitionTime sets the row's partition time and routes events outside the lateness window, tz is the uri_tz parameter
+func (p *Pipeline) partitionTime(logT *log.Log, row *Row, tz string) {
+	var req = logT.RequestTime()
+	var evt = req
+	if row.Event != nil && row.Event.Timestamp >= 1000 {
+		evt = row.Event.Time()
+
+		if p.Lateness > 0 {
+			switch {
+			case req.Sub(evt) > p.Lateness:
+				row.Bucket = BucketLate
+			case evt.Sub(req) > p.Lateness:
+				row.Bucket = BucketFuture
+			}
+		}
+	}
+
+	switch p.PartitionBy {
+	case PartitionEvent:
+		row.Time = evt.In(p.location())
+	case PartitionDevice:
+		var loc = deviceZone(tz)
+		if loc == nil {
+			loc = p.location()
+		}
+		row.Time = evt.In(loc)
+	default:
+		row.Time = req.In(p.location())
+	}
+}
+
+//maxZones bounds the names remembered by zones, unusable ones included, the names start over once it is reached
+const maxZones = 1024
+
+//maxOffset is the furthest from UTC a zone can be, in minutes
+const maxOffset = 14 * 60
+
+//zoneCache holds the zones deviceZone returned
+type zoneCache struct {
+	sync.RWMutex
+	//named holds IANA names, nil for those that failed to load
+	named map[string]*time.Location
+	//fixed holds offsets by minutes east of UTC
+	fixed map[int]*time.Location
+}
+
+var zones = &zoneCache{
+	named: make(map[string]*time.Location),
+	fixed: make(map[int]*time.Location),
+}
+
+//loadLocation is time.LoadLocation, tests count the calls
+var loadLocation = time.LoadLocation
+
+//deviceZone parses uri_tz, which SDKs send as an IANA name (America/Chicago), an offset (+05:30, -0500)
+//or a number of hours east of UTC (-5, 5.5), numbers beyond 14 are taken as minutes (330).
+//It returns nil if tz is unusable or more than 14 hours from UTC.
+func deviceZone(tz string) *time.Location {
+	tz = strings.TrimSpace(tz)
+	if tz == "" {
+		return nil
+	}
+
+	//offsets are kept by minute, so 5.5, 5.50, 330 and +05:30 share a zone.
+	//-0500 is also a number, the layouts go first
+	for _, layout := range []string{"-07:00", "-0700"} {
+		if t, err := time.Parse(layout, tz); err == nil {
+			_, secs := t.Zone()
+			return zones.offset(secs / 60)
+		}
+	}
+	if f, err := strconv.ParseFloat(tz, 64); err == nil {
+		var mins = f * 60
+		if f > 14 || f < -14 {
+			mins = f
+		}
+		//also false for NaN
+		if !(mins >= -maxOffset && mins <= maxOffset) {
+			return nil
+		}
+		return zones.offset(int(math.Round(mins)))
+	}
+
+	return zones.name(tz)
+}
+
+func (c *zoneCache) offset(mins int) *time.Location {
+	if mins < -maxOffset || mins > maxOffset {
+		return nil
+	}
+
+	c.RLock()
+	loc, ok := c.fixed[mins]
+	c.RUnlock()
+	if ok {
+		return loc
+	}
+
+	var sign, abs = "+", mins
+	if mins < 0 {
+		sign, abs = "-", -mins
+	}
+	loc = time.FixedZone(fmt.Sprintf("%s%02d:%02d", sign, abs/60, abs%60), mins*60)
+
+	c.Lock()
+	c.fixed[mins] = loc
+	c.Unlock()
+	return loc
+}
+
+func (c *zoneCache) name(tz string) *time.Location {
+	c.RLock()
+	loc, ok := c.named[tz]
+	c.RUnlock()
+	if ok {
+		return loc
+	}
+
+	loc, err := loadLocation(tz)
+	if err != nil {
+		loc = nil
+	}
+
+	c.Lock()
+	if len(c.named) >= maxZones {
+		c.named = make(map[string]*time.Location)
+	}
+	c.named[tz] = loc
+	c.Unlock()
+	return loc
+}
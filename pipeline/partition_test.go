@@ -0,0 +1,71 @@
+package pipeline
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDeviceZone(t *testing.T) {
+	var at = time.Date(2017, 12, 1, 20, 0, 0, 0, time.UTC)
+
+	for tz, want := range map[string]int{
+		"America/Chicago": -6 * 3600,
+		"UTC":             0,
+		"+05:30":          5*3600 + 1800,
+		"-0500":           -5 * 3600,
+		"-5":              -5 * 3600,
+		"5.5":             5*3600 + 1800,
+		" 5.50 ":          5*3600 + 1800,
+		"330":             5*3600 + 1800,
+		"-330":            -5*3600 - 1800,
+		"14":              14 * 3600,
+	} {
+		var loc = deviceZone(tz)
+		if loc == nil {
+			t.Errorf("%q: no zone", tz)
+			continue
+		}
+		if _, off := at.In(loc).Zone(); off != want {
+			t.Errorf("%q: offset %d, want %d", tz, off, want)
+		}
+	}
+
+	for _, tz := range []string{"", "Mars/Olympus_Mons", "+5:3O", "NaN", "Inf", "1000", "+23:00"} {
+		if loc := deviceZone(tz); loc != nil {
+			t.Errorf("%q: got %v, want nil", tz, loc)
+		}
+	}
+
+	//every spelling of an offset shares one zone
+	if a, b := deviceZone("5.5"), deviceZone("+05:30"); a != b || a.String() != "+05:30" {
+		t.Errorf("5.5 = %v, +05:30 = %v", a, b)
+	}
+}
+
+func TestDeviceZoneCachesNames(t *testing.T) {
+	var calls = make(map[string]int)
+	defer func(f func(string) (*time.Location, error)) { loadLocation = f }(loadLocation)
+	loadLocation = func(name string) (*time.Location, error) {
+		calls[name]++
+		return time.LoadLocation(name)
+	}
+
+	for i := 0; i < 3; i++ {
+		deviceZone("Europe/Berlin")
+		deviceZone("Not/AZone")
+	}
+	if calls["Europe/Berlin"] != 1 || calls["Not/AZone"] != 1 {
+		t.Fatalf("loaded %v, want each name once", calls)
+	}
+
+	for i := 0; i < 3*maxZones; i++ {
+		deviceZone(fmt.Sprintf("junk-%d", i))
+	}
+	zones.RLock()
+	var n = len(zones.named)
+	zones.RUnlock()
+	if n > maxZones {
+		t.Fatalf("%d names cached, want at most %d", n, maxZones)
+	}
+}
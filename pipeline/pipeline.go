@@ -0,0 +1,556 @@
+package pipeline
+
+import (
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/random9s/Analytics-Pipeline/log"
+)
+
+//Line is a raw log line along with where it was read from
+type Line struct {
+	Text  string
+	Input string
+	Num   int64
+	//Offset is the position in the decompressed input just past this line
+	Offset int64
+}
+
+//Row is a converted csv record
+type Row struct {
+	Fields []string
+	//Time decides which output partition the row belongs to
+	Time time.Time
+	//Bucket, when set, routes the row to a separate area of the output such as BucketLate
+	Bucket string
+	//Input is where the line the row was built from was read
+	Input string
+	//Log is the log the row was built from
+	Log *log.Log
+	//Event is the event the row was built from, nil for a log without events
+	Event *log.Event
+	//Index is the position of Event among the events of its log
+	Index int
+
+	schema *Schema
+}
+
+//Set fills every column read from source, Enrichers use it for their own namespace such as geo.city
+func (r *Row) Set(source, v string) {
+	if r.schema == nil {
+		return
+	}
+
+	for _, i := range r.schema.bySource[source] {
+		r.Fields[i] = v
+	}
+}
+
+//Get returns the value of the named column
+func (r *Row) Get(name string) string {
+	if r.schema == nil {
+		return ""
+	}
+
+	if i := r.schema.Index(name); i >= 0 {
+		return r.Fields[i]
+	}
+
+	return ""
+}
+
+//Source supplies raw log lines, returning io.EOF once exhausted
+type Source interface {
+	Next() (*Line, error)
+}
+
+//Decoder turns a raw log line into a Log
+type Decoder interface {
+	Decode(line *Line) (*log.Log, error)
+}
+
+//Enricher fills in columns that are not taken directly from the log
+type Enricher interface {
+	Enrich(l *log.Log, row *Row) error
+}
+
+//Sink receives converted rows, its methods are never called concurrently
+type Sink interface {
+	Write(row *Row) error
+	//Flush pushes buffered rows through to storage
+	Flush() error
+	Close() error
+}
+
+//Committer is implemented by sinks whose output can be checkpointed
+type Committer interface {
+	//Commit makes every row written so far durable and returns the committed size of each output file
+	Commit() (map[string]int64, error)
+	//Restore truncates output files back to sizes returned by an earlier Commit
+	Restore(sizes map[string]int64) error
+	//OnOpen registers fn to be called with an output file's size before it is first written to
+	OnOpen(fn func(path string, size int64) error)
+}
+
+//Aborter is implemented by sinks and dead letters that can discard unfinished output when a run fails
+type Aborter interface {
+	//Abort closes the sink without publishing anything still in progress
+	Abort() error
+}
+
+//Pipeline reads lines from a Source, converts them into rows and writes them to a Sink
+type Pipeline struct {
+	Source    Source
+	Decoder   Decoder
+	Enrichers []Enricher
+	Sink      Sink
+	//Schema lays out the output columns, DefaultSchema() if nil
+	Schema *Schema
+
+	//Workers is the number of conversion goroutines, defaults to runtime.NumCPU()
+	Workers int
+	//Location is the timezone timestamps are written in and partitioned by, defaults to UTC
+	Location *time.Location
+	//PartitionBy is the time rows are partitioned on, PartitionRequest by default
+	PartitionBy string
+	//Lateness, when set, routes events sent more than this long after they happened to BucketLate
+	//and events dated more than this long after they were sent to BucketFuture
+	Lateness time.Duration
+	//FlushInterval, when set, flushes the sink periodically
+	FlushInterval time.Duration
+	//Checkpoint, when set, records progress every CheckpointEvery lines and at the end of the run
+	Checkpoint      *Checkpoint
+	CheckpointEvery int64
+	//ProgressInterval, when set, writes a Progress line to ProgressWriter at that interval, as json with ProgressJSON
+	ProgressInterval time.Duration
+	ProgressWriter   io.Writer
+	ProgressJSON     bool
+	//OnFlush, when set, is called with the time each flush or checkpoint commit of the sink took
+	OnFlush func(d time.Duration)
+	//Unknown is what happens to event keys and request parameters no column reads, UnknownIgnore by default.
+	//With UnknownPromote, Schema holds the grown schema once Run returns.
+	Unknown string
+
+	//Policy decides what happens to lines that cannot be converted, DefaultPolicy if nil
+	Policy Policy
+	//DeadLetter receives lines rejected with ActionDeadLetter, Run closes it or, when the run fails, aborts it
+	DeadLetter DeadLetter
+
+	unknown   unknown
+	coercions counter
+	rejects   counter
+	clients   counter
+	events    counter
+
+	start, end time.Time
+
+	mu  sync.Mutex
+	in  chan *Line
+	out chan *Row
+
+	readLines, writeLines, skipLines, filterLines, decoded, bytesIn int64
+}
+
+//New creates a pipeline using the default LogDecoder
+func New(src Source, sink Sink, enrichers ...Enricher) *Pipeline {
+	return &Pipeline{
+		Source:    src,
+		Decoder:   new(LogDecoder),
+		Enrichers: enrichers,
+		Sink:      sink,
+		Schema:    DefaultSchema(),
+		Workers:   runtime.NumCPU(),
+	}
+}
+
+//Counts returns the number of lines read, rows written and lines skipped so far
+func (p *Pipeline) Counts() (read, written, skipped int64) {
+	return atomic.LoadInt64(&p.readLines), atomic.LoadInt64(&p.writeLines), atomic.LoadInt64(&p.skipLines)
+}
+
+//Decoded returns the number of lines decoded so far
+func (p *Pipeline) Decoded() int64 {
+	return atomic.LoadInt64(&p.decoded)
+}
+
+//Queuer is implemented by sources that buffer lines ahead of the pipeline
+type Queuer interface {
+	Queued() int
+}
+
+//QueueDepths returns the number of items waiting between the stages: in the source's buffer,
+//between the reader and the workers and between the workers and the writer
+func (p *Pipeline) QueueDepths() (source, in, out int) {
+	if q, ok := p.Source.(Queuer); ok {
+		source = q.Queued()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return source, len(p.in), len(p.out)
+}
+
+//Filtered returns the number of lines the decoder left out on purpose, they are not counted as skipped
+func (p *Pipeline) Filtered() int64 {
+	return atomic.LoadInt64(&p.filterLines)
+}
+
+//Coercions returns how many event values were converted to their field's type, by key and original type, e.g. "fc:string"
+func (p *Pipeline) Coercions() map[string]int64 {
+	return p.coercions.snapshot()
+}
+
+//counter counts occurrences of keys from several goroutines
+type counter struct {
+	mu sync.Mutex
+	m  map[string]int64
+}
+
+func (c *counter) add(key string, n int64) {
+	c.mu.Lock()
+	if c.m == nil {
+		c.m = make(map[string]int64)
+	}
+	c.m[key] += n
+	c.mu.Unlock()
+}
+
+func (c *counter) snapshot() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var m = make(map[string]int64, len(c.m))
+	for k, n := range c.m {
+		m[k] = n
+	}
+
+	return m
+}
+
+//firstErr records the first error raised by any stage and signals the others to stop
+type firstErr struct {
+	once sync.Once
+	err  error
+	quit chan struct{}
+}
+
+func (f *firstErr) set(err error) {
+	f.once.Do(func() {
+		f.err = err
+		close(f.quit)
+	})
+}
+
+func (f *firstErr) failed() bool {
+	select {
+	case <-f.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+//Run processes the source until it is exhausted or a stage fails, the sink is always closed or aborted
+func (p *Pipeline) Run() error {
+	p.start = time.Now()
+	defer func() { p.end = time.Now() }()
+
+	var workers = p.Workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	var committer, _ = p.Sink.(Committer)
+	if p.Checkpoint != nil && committer != nil {
+		//drop whatever an interrupted run wrote after its last checkpoint
+		if err := committer.Restore(p.Checkpoint.Outputs()); err != nil {
+			return err
+		}
+		committer.OnOpen(p.Checkpoint.AddOutput)
+	}
+	if dl, ok := p.DeadLetter.(Committer); ok && p.Checkpoint != nil {
+		//rejected lines are kept apart from the outputs, the sink would take them for its own files
+		if err := dl.Restore(p.Checkpoint.Rejects()); err != nil {
+			return err
+		}
+		dl.OnOpen(p.Checkpoint.AddReject)
+	}
+
+	var fe = &firstErr{quit: make(chan struct{})}
+	var in = make(chan *Line, workers)
+	var out = make(chan *Row, workers)
+	var wg = sync.WaitGroup{}
+
+	p.mu.Lock()
+	p.in, p.out = in, out
+	p.mu.Unlock()
+
+	//pending counts lines handed to workers that have not been fully written yet
+	var pending = new(sync.WaitGroup)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(in, out, pending, fe)
+		}()
+	}
+
+	var commitc = make(chan chan error)
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		p.write(out, commitc, pending, fe)
+	}()
+
+	//a source blocked waiting on more input is closed as soon as any stage fails
+	var finished = make(chan struct{})
+	defer close(finished)
+
+	if p.ProgressInterval > 0 && p.ProgressWriter != nil {
+		go p.reportProgress(finished)
+	}
+	if c, ok := p.Source.(io.Closer); ok {
+		go func() {
+			select {
+			case <-fe.quit:
+				c.Close()
+			case <-finished:
+			}
+		}()
+	}
+
+	//progress holds the position of the last line read from each input
+	var progress = make(map[string]InputState)
+	var every = p.CheckpointEvery
+	if every < 1 {
+		every = defaultCheckpointEvery
+	}
+
+	//read until EOF
+read:
+	for {
+		line, err := p.Source.Next()
+		if err == io.EOF {
+			if p.Checkpoint != nil {
+				if err := p.checkpoint(progress, commitc, pending, fe); err != nil {
+					fe.set(err)
+				}
+			}
+			break
+		}
+		if err != nil {
+			fe.set(err)
+			break
+		}
+
+		pending.Add(1)
+		select {
+		case in <- line:
+			progress[line.Input] = InputState{line.Offset, line.Num}
+		case <-fe.quit:
+			pending.Done()
+			break read
+		}
+
+		atomic.AddInt64(&p.bytesIn, int64(len(line.Text)))
+		var n = atomic.AddInt64(&p.readLines, 1)
+		if p.Checkpoint != nil && n%every == 0 {
+			if err := p.checkpoint(progress, commitc, pending, fe); err != nil {
+				fe.set(err)
+				break
+			}
+		}
+	}
+
+	//let the source release anything it still holds
+	if c, ok := p.Source.(io.Closer); ok {
+		c.Close()
+	}
+
+	close(in)
+	wg.Wait()
+
+	close(out)
+	<-done
+
+	if p.unknown.schema != nil {
+		p.Schema = p.unknown.schema
+	}
+
+	//only a successful run publishes its output
+	if a, ok := p.Sink.(Aborter); ok && fe.failed() {
+		a.Abort()
+	} else if err := p.Sink.Close(); err != nil {
+		fe.set(err)
+	}
+
+	//rejected lines are published with the output, or kept back with it
+	if a, ok := p.DeadLetter.(Aborter); ok && fe.failed() {
+		a.Abort()
+	} else if p.DeadLetter != nil {
+		if err := p.DeadLetter.Close(); err != nil {
+			fe.set(err)
+		}
+	}
+
+	//the published files are no longer the checkpoint's to cut back
+	if p.Checkpoint != nil && !fe.failed() {
+		if err := p.Checkpoint.Done(); err != nil {
+			fe.set(err)
+		}
+	}
+
+	return fe.err
+}
+
+//checkpoint waits for every line read so far to be written, commits the sink and saves the checkpoint
+func (p *Pipeline) checkpoint(progress map[string]InputState, commitc chan chan error, pending *sync.WaitGroup, fe *firstErr) error {
+	pending.Wait()
+
+	//never record progress past a line that failed
+	if fe.failed() {
+		return nil
+	}
+
+	var reply = make(chan error)
+	commitc <- reply
+	if err := <-reply; err != nil {
+		return err
+	}
+
+	return p.Checkpoint.Save(progress)
+}
+
+func (p *Pipeline) work(in chan *Line, out chan *Row, pending *sync.WaitGroup, fe *firstErr) {
+	for line := range in {
+		logT, err := p.Decoder.Decode(line)
+		if err == ErrFiltered {
+			atomic.AddInt64(&p.filterLines, 1)
+			pending.Done()
+			continue
+		}
+		if err != nil {
+			p.reject(line, classify(ClassDecode, err), fe)
+			pending.Done()
+			continue
+		}
+
+		atomic.AddInt64(&p.decoded, 1)
+
+		for _, ev := range logT.Events {
+			for _, c := range ev.Coercions() {
+				p.coercions.add(c.Key+":"+c.From, 1)
+			}
+		}
+
+		rows, err := p.Convert(logT)
+		if err != nil {
+			p.reject(line, err, fe)
+			pending.Done()
+			continue
+		}
+
+		//every row beyond the first is one more to wait for
+		pending.Add(len(rows) - 1)
+		for i, row := range rows {
+			row.Input = line.Input
+			select {
+			case out <- row:
+				continue
+			case <-fe.quit:
+			}
+
+			//rows that will never be written are no longer waited for
+			pending.Add(i - len(rows))
+			break
+		}
+	}
+}
+
+//write is the only goroutine touching the sink
+func (p *Pipeline) write(out chan *Row, commitc chan chan error, pending *sync.WaitGroup, fe *firstErr) {
+	var tick <-chan time.Time
+	if p.FlushInterval > 0 {
+		var t = time.NewTicker(p.FlushInterval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for {
+		select {
+		case row, ok := <-out:
+			if !ok {
+				return
+			}
+			if err := p.Sink.Write(row); err != nil {
+				fe.set(err)
+			} else {
+				atomic.AddInt64(&p.writeLines, 1)
+				p.count(row)
+			}
+			pending.Done()
+		case <-tick:
+			var t = time.Now()
+			if err := p.Sink.Flush(); err != nil {
+				fe.set(err)
+			}
+			p.flushed(time.Since(t))
+		case reply := <-commitc:
+			var t = time.Now()
+			var err = p.commit()
+			p.flushed(time.Since(t))
+			reply <- err
+		}
+	}
+}
+
+//count adds a written row to the per client and per event counts, only the writer calls it so the locks are
+//only ever contended by a Stats snapshot
+func (p *Pipeline) count(row *Row) {
+	var name string
+	if row.Event != nil {
+		name = row.Event.Name
+	}
+
+	var client string
+	if row.Log != nil {
+		client = row.Log.ClientID
+	}
+
+	p.clients.add(client, 1)
+	p.events.add(name, 1)
+}
+
+func (p *Pipeline) flushed(d time.Duration) {
+	if p.OnFlush != nil {
+		p.OnFlush(d)
+	}
+}
+
+func (p *Pipeline) commit() error {
+	if dl, ok := p.DeadLetter.(Committer); ok {
+		sizes, err := dl.Commit()
+		if err != nil {
+			return err
+		}
+		p.Checkpoint.SetRejects(sizes)
+	}
+
+	c, ok := p.Sink.(Committer)
+	if !ok {
+		return p.Sink.Flush()
+	}
+
+	sizes, err := c.Commit()
+	if err != nil {
+		return err
+	}
+
+	p.Checkpoint.SetOutputs(sizes)
+	return nil
+}
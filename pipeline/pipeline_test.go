@@ -0,0 +1,198 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+//eventsLine is a request from device did on 2017-12-01 batching n events named e0, e1...
+func eventsLine(did string, n int) string {
+	var evs = make([]string, n)
+	for i := range evs {
+		evs[i] = fmt.Sprintf(`{"n":"e%d"}`, i)
+	}
+
+	return fmt.Sprintf(`{"REQUEST_TIME_FLOAT":1512161708,"REQUEST_URI":"/sdk/v1?d=%s","event":[%s]}`, did, strings.Join(evs, ",")) + "\n"
+}
+
+//events returns uri_did/event_n of every row of a gzipped csv file, sorted
+func events(t *testing.T, path string) []string {
+	t.Helper()
+
+	var did, n = DefaultSchema().Index("uri_did"), DefaultSchema().Index("event_n")
+	var evs []string
+	for _, rec := range readGzipCSV(t, path) {
+		evs = append(evs, rec[did]+"/"+rec[n])
+	}
+	sort.Strings(evs)
+
+	return evs
+}
+
+//wantEvents lists what events returns for lines made by eventsLine
+func wantEvents(dids []string, n int) []string {
+	var evs []string
+	for _, did := range dids {
+		for i := 0; i < n; i++ {
+			evs = append(evs, fmt.Sprintf("%s/e%d", did, i))
+		}
+	}
+	sort.Strings(evs)
+
+	return evs
+}
+
+//commitSink records how many rows had been written at each commit
+type commitSink struct {
+	*FileSink
+	commits []int64
+}
+
+func (s *commitSink) Commit() (map[string]int64, error) {
+	var n int64
+	for _, rows := range s.Partitions() {
+		n += rows
+	}
+	s.commits = append(s.commits, n)
+
+	return s.FileSink.Commit()
+}
+
+//failAfter fails once n lines were read
+type failAfter struct {
+	Source
+	n int
+}
+
+func (s *failAfter) Next() (*Line, error) {
+	if s.n == 0 {
+		return nil, errors.New("input went away")
+	}
+	s.n--
+
+	return s.Source.Next()
+}
+
+func TestRunMultiEventRows(t *testing.T) {
+	var dir, in = t.TempDir(), t.TempDir()
+	var ck = filepath.Join(in, "ck.json")
+	var dids = []string{"a", "b", "c", "d", "e"}
+	var text string
+	for _, did := range dids {
+		text += eventsLine(did, 3)
+	}
+	writeFile(t, filepath.Join(in, "in.log"), text)
+
+	cp, err := LoadCheckpoint(ck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sink = &commitSink{FileSink: NewFileSink(dir, nil)}
+	var p = New(NewFileSource([]string{filepath.Join(in, "in.log")}, "auto", 1, nil), sink)
+	p.Workers = 4
+	p.Checkpoint = cp
+	p.CheckpointEvery = 2
+	if err := p.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	//every row of the lines read is written before a commit, however many events a line holds
+	if !reflect.DeepEqual(sink.commits, []int64{6, 12, 15}) {
+		t.Fatalf("rows written at each commit: %v", sink.commits)
+	}
+	if read, written, _ := p.Counts(); read != 5 || written != 15 {
+		t.Fatalf("read %d, wrote %d", read, written)
+	}
+	if evs := events(t, filepath.Join(dir, "sdk-log-2017.12.01.csv.gz")); !reflect.DeepEqual(evs, wantEvents(dids, 3)) {
+		t.Fatalf("rows %v", evs)
+	}
+
+	//a completed run leaves nothing to cut back
+	cp, err = LoadCheckpoint(ck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.Outputs()) != 0 {
+		t.Fatalf("outputs left in the checkpoint: %v", cp.Outputs())
+	}
+}
+
+func TestRunFailsAndResumes(t *testing.T) {
+	var dir, in = t.TempDir(), t.TempDir()
+	var ck = filepath.Join(in, "ck.json")
+	var files = []string{filepath.Join(in, "in.log")}
+	var out = filepath.Join(dir, "sdk-log-2017.12.01.csv.gz")
+	var dids = []string{"a", "b", "c", "d", "e", "f"}
+	var text string
+	for _, did := range dids {
+		text += eventsLine(did, 2)
+	}
+	writeFile(t, files[0], text)
+
+	var run = func(src func(resume map[string]InputState) Source) error {
+		cp, err := LoadCheckpoint(ck)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var p = New(src(cp.Inputs()), NewFileSink(dir, nil))
+		p.Workers = 2
+		p.Checkpoint = cp
+		p.CheckpointEvery = 2
+		return p.Run()
+	}
+
+	//the fifth line is written after the checkpoint at line 4, then the input fails
+	err := run(func(resume map[string]InputState) Source {
+		return &failAfter{Source: NewFileSource(files, "auto", 1, resume), n: 5}
+	})
+	if err == nil {
+		t.Fatal("expected the failing input to fail the run")
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("published by a failed run: %v", err)
+	}
+	//the output is aborted, not closed, and kept for the checkpoint
+	if evs := events(t, tempPath(out)); len(evs) < 8 {
+		t.Fatalf("committed rows %v", evs)
+	}
+
+	err = run(func(resume map[string]InputState) Source {
+		return NewFileSource(files, "auto", 1, resume)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evs := events(t, out); !reflect.DeepEqual(evs, wantEvents(dids, 2)) {
+		t.Fatalf("rows %v", evs)
+	}
+	if _, err := os.Stat(tempPath(out)); !os.IsNotExist(err) {
+		t.Fatalf("temporary file left behind: %v", err)
+	}
+}
+
+func TestRunFailsWithoutCheckpoint(t *testing.T) {
+	var dir, in = t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(in, "in.log"), eventsLine("a", 2)+eventsLine("b", 2))
+
+	var p = New(&failAfter{Source: NewFileSource([]string{filepath.Join(in, "in.log")}, "auto", 1, nil), n: 1}, NewFileSink(dir, nil))
+	if err := p.Run(); err == nil {
+		t.Fatal("expected the failing input to fail the run")
+	}
+
+	//nothing is published and nothing kept
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("left behind %v", entries)
+	}
+}
@@ -0,0 +1,130 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+//SizedSource is implemented by sources that know how many bytes they will read in total, which allows an ETA
+type SizedSource interface {
+	ByteReader
+	BytesTotal() int64
+}
+
+//Progress is a snapshot of a running pipeline
+type Progress struct {
+	Time    time.Time `json:"time"`
+	Elapsed float64   `json:"elapsed_seconds"`
+
+	LinesRead   int64 `json:"lines_read"`
+	RowsWritten int64 `json:"rows_written"`
+	//Rejected counts skipped and dead-lettered lines
+	Rejected int64 `json:"rejected"`
+
+	//Rate is the number of lines read per second since the previous report
+	Rate float64 `json:"lines_per_second"`
+
+	//BytesRead is read from the inputs before decompression, BytesIn came out of the decompressor
+	BytesRead int64 `json:"bytes_read"`
+	BytesIn   int64 `json:"bytes_in"`
+	//BytesTotal, Percent and ETA are only known for file inputs
+	BytesTotal int64   `json:"bytes_total,omitempty"`
+	Percent    float64 `json:"percent,omitempty"`
+	ETA        float64 `json:"eta_seconds,omitempty"`
+}
+
+//Progress reports on the pipeline, the rate is taken since prev which may be nil
+func (p *Pipeline) Progress(prev *Progress) *Progress {
+	var pr = &Progress{
+		Time:        time.Now(),
+		LinesRead:   atomic.LoadInt64(&p.readLines),
+		RowsWritten: atomic.LoadInt64(&p.writeLines),
+		Rejected:    atomic.LoadInt64(&p.skipLines),
+		BytesIn:     atomic.LoadInt64(&p.bytesIn),
+	}
+	pr.Elapsed = pr.Time.Sub(p.start).Seconds()
+
+	if prev != nil {
+		if d := pr.Time.Sub(prev.Time).Seconds(); d > 0 {
+			pr.Rate = float64(pr.LinesRead-prev.LinesRead) / d
+		}
+	} else if pr.Elapsed > 0 {
+		pr.Rate = float64(pr.LinesRead) / pr.Elapsed
+	}
+
+	if b, ok := p.Source.(ByteReader); ok {
+		pr.BytesRead = b.BytesRead()
+	}
+
+	//the ETA assumes the remaining bytes go at the average speed so far
+	if s, ok := p.Source.(SizedSource); ok && s.BytesTotal() > 0 {
+		pr.BytesTotal = s.BytesTotal()
+		pr.Percent = 100 * float64(pr.BytesRead) / float64(pr.BytesTotal)
+		if pr.BytesRead > 0 && pr.Elapsed > 0 {
+			var speed = float64(pr.BytesRead) / pr.Elapsed
+			pr.ETA = float64(pr.BytesTotal-pr.BytesRead) / speed
+		}
+	}
+
+	return pr
+}
+
+//WriteText writes the snapshot as a single line
+func (pr *Progress) WriteText(w io.Writer) error {
+	var line = fmt.Sprintf("%s read %d lines, wrote %d rows, rejected %d | %.0f lines/s | %s in, %s decompressed",
+		pr.Time.Format("15:04:05"), pr.LinesRead, pr.RowsWritten, pr.Rejected, pr.Rate,
+		byteSize(pr.BytesRead), byteSize(pr.BytesIn))
+	if pr.BytesTotal > 0 {
+		line += fmt.Sprintf(" | %.1f%% of %s, ETA %s", pr.Percent, byteSize(pr.BytesTotal),
+			time.Duration(pr.ETA*float64(time.Second)).Round(time.Second))
+	}
+
+	_, err := fmt.Fprintln(w, line)
+	return err
+}
+
+//byteSize formats n with a binary unit
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	var div, exp = int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+//reportProgress writes a snapshot to ProgressWriter every ProgressInterval until done is closed
+func (p *Pipeline) reportProgress(done chan struct{}) {
+	var t = time.NewTicker(p.ProgressInterval)
+	defer t.Stop()
+
+	var prev *Progress
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+
+		var pr = p.Progress(prev)
+		prev = pr
+
+		if p.ProgressJSON {
+			b, err := json.Marshal(pr)
+			if err == nil {
+				p.ProgressWriter.Write(append(b, '\n'))
+			}
+			continue
+		}
+		pr.WriteText(p.ProgressWriter)
+	}
+}
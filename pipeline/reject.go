@@ -0,0 +1,358 @@
+package pipeline
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//Classes of errors a line can be rejected for
+const (
+	//ClassDecode is a line that is not valid json or holds values that cannot be converted
+	ClassDecode = "decode"
+	//ClassURI is a REQUEST_URI that cannot be parsed
+	ClassURI = "uri"
+	//ClassEnrich is an error returned by an Enricher, e.g. a failed GeoIP lookup
+	ClassEnrich = "enrich"
+	//ClassConvert is any other error raised while converting a line
+	ClassConvert = "convert"
+)
+
+//Actions a Policy can take on a rejected line
+const (
+	//ActionSkip drops the line and carries on
+	ActionSkip = "skip"
+	//ActionFail stops the run
+	ActionFail = "fail"
+	//ActionDeadLetter writes the line to the pipeline's DeadLetter and carries on
+	ActionDeadLetter = "dead-letter"
+)
+
+//LineError is an error tied to a class, Enrichers may return one to pick their own class
+type LineError struct {
+	Class string
+	Err   error
+}
+
+func (e *LineError) Error() string {
+	return e.Class + ": " + e.Err.Error()
+}
+
+//classify wraps err in a LineError of class unless it already is one
+func classify(class string, err error) error {
+	if _, ok := err.(*LineError); ok {
+		return err
+	}
+
+	return &LineError{class, err}
+}
+
+//Policy maps error classes to actions, classes it does not list use DefaultPolicy
+type Policy map[string]string
+
+//DefaultPolicy skips lines that cannot be decoded and fails on anything else
+var DefaultPolicy = Policy{
+	ClassDecode:  ActionSkip,
+	ClassURI:     ActionFail,
+	ClassEnrich:  ActionFail,
+	ClassConvert: ActionFail,
+}
+
+//ParsePolicy reads a comma separated list of class=action pairs, e.g. "decode=dead-letter,enrich=skip"
+func ParsePolicy(spec string) (Policy, error) {
+	var p = make(Policy)
+	for _, kv := range strings.Split(spec, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+
+		var i = strings.IndexByte(kv, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("%q is not class=action", kv)
+		}
+
+		var class, action = kv[:i], kv[i+1:]
+		switch action {
+		case ActionSkip, ActionFail, ActionDeadLetter:
+		default:
+			return nil, fmt.Errorf("unknown action %q for %s", action, class)
+		}
+		p[class] = action
+	}
+
+	return p, nil
+}
+
+//Action returns what to do with a line rejected for class
+func (p Policy) Action(class string) string {
+	if a, ok := p[class]; ok {
+		return a
+	}
+	if a, ok := DefaultPolicy[class]; ok {
+		return a
+	}
+
+	return ActionFail
+}
+
+//Uses reports whether any class is mapped to action
+func (p Policy) Uses(action string) bool {
+	for _, a := range p {
+		if a == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+//DeadLetter stores rejected lines, Reject is called from several goroutines
+type DeadLetter interface {
+	Reject(line *Line, class string, reason error) error
+	Close() error
+}
+
+//deadRecord is one line of a DeadLetterFile
+type deadRecord struct {
+	Input  string `json:"input"`
+	Line   int64  `json:"line"`
+	Class  string `json:"class"`
+	Reason string `json:"reason"`
+	Text   string `json:"text"`
+}
+
+//DeadLetterFile writes rejected lines to a gzipped file of json records holding
+//the input file, line number, error class and reason along with the raw line.
+//The file is only created once a line is rejected and, like sink outputs, written under a temporary name until Close.
+//It is a Committer, so a checkpointed run resumes the file rather than starting it over.
+type DeadLetterFile struct {
+	Path string
+
+	mu     sync.Mutex
+	fp     *os.File
+	zw     *gzip.Writer
+	enc    *json.Encoder
+	n      int64
+	dirty  bool
+	onOpen func(path string, size int64) error
+}
+
+//NewDeadLetterFile ...
+func NewDeadLetterFile(path string) *DeadLetterFile {
+	return &DeadLetterFile{Path: path}
+}
+
+//Reject ...
+func (d *DeadLetterFile) Reject(line *Line, class string, reason error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.fp == nil {
+		if err := os.MkdirAll(filepath.Dir(d.Path), 0755); err != nil {
+			return err
+		}
+		if err := d.open(os.O_TRUNC); err != nil {
+			return err
+		}
+	}
+
+	d.dirty = true
+	atomic.AddInt64(&d.n, 1)
+	return d.enc.Encode(deadRecord{
+		Input:  line.Input,
+		Line:   line.Num,
+		Class:  class,
+		Reason: reason.Error(),
+		Text:   strings.TrimRight(line.Text, "\r\n"),
+	})
+}
+
+//open opens the temporary file for appending, with flag added
+func (d *DeadLetterFile) open(flag int) error {
+	var tmp = tempPath(d.Path)
+	fp, err := os.OpenFile(tmp, os.O_APPEND|os.O_WRONLY|os.O_CREATE|flag, 0644)
+	if err != nil {
+		return err
+	}
+
+	if d.onOpen != nil {
+		fi, err := fp.Stat()
+		if err == nil {
+			err = d.onOpen(tmp, fi.Size())
+		}
+		if err != nil {
+			fp.Close()
+			return err
+		}
+	}
+
+	d.fp = fp
+	d.zw = gzip.NewWriter(fp)
+	d.enc = json.NewEncoder(d.zw)
+	return nil
+}
+
+//Commit ends the current gzip member and returns the size of the temporary file, if it was created
+func (d *DeadLetterFile) Commit() (map[string]int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var sizes = make(map[string]int64, 1)
+	if d.fp == nil {
+		return sizes, nil
+	}
+
+	if d.dirty {
+		if err := d.zw.Close(); err != nil {
+			return nil, err
+		}
+		if err := d.fp.Sync(); err != nil {
+			return nil, err
+		}
+		d.zw.Reset(d.fp)
+		d.dirty = false
+	}
+
+	fi, err := d.fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	sizes[tempPath(d.Path)] = fi.Size()
+
+	return sizes, nil
+}
+
+//Restore truncates the temporary file back to its committed size and continues it, so it is published by Close
+//even if no further lines are rejected. A file that was empty is removed.
+func (d *DeadLetterFile) Restore(sizes map[string]int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var tmp = tempPath(d.Path)
+	size, ok := sizes[tmp]
+	if !ok {
+		return nil
+	}
+	if size == 0 {
+		if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	fi, err := os.Stat(tmp)
+	if os.IsNotExist(err) {
+		//already published
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Size() < size {
+		return fmt.Errorf("%s: %d bytes, smaller than the %d the checkpoint committed", tmp, fi.Size(), size)
+	}
+	if err := os.Truncate(tmp, size); err != nil {
+		return err
+	}
+
+	return d.open(0)
+}
+
+//OnOpen ...
+func (d *DeadLetterFile) OnOpen(fn func(path string, size int64) error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.onOpen = fn
+}
+
+//Count returns the number of lines written so far
+func (d *DeadLetterFile) Count() int64 {
+	return atomic.LoadInt64(&d.n)
+}
+
+//Close ends the gzip stream and renames the file into place, a file that was never created stays absent
+func (d *DeadLetterFile) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.fp == nil {
+		return nil
+	}
+
+	var err = d.zw.Close()
+	if serr := d.fp.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := d.fp.Close(); err == nil {
+		err = cerr
+	}
+	d.fp = nil
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath(d.Path), d.Path)
+}
+
+//Abort closes the file without publishing it. The temporary file is kept for a checkpointed run
+//to resume from, otherwise it is removed.
+func (d *DeadLetterFile) Abort() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.fp == nil {
+		return nil
+	}
+
+	var err = d.fp.Close()
+	d.fp = nil
+	if d.onOpen == nil {
+		if rmErr := os.Remove(tempPath(d.Path)); err == nil {
+			err = rmErr
+		}
+	}
+
+	return err
+}
+
+//Rejected returns the number of lines rejected so far by error class
+func (p *Pipeline) Rejected() map[string]int64 {
+	return p.rejects.snapshot()
+}
+
+//reject applies the policy to a line that could not be converted
+func (p *Pipeline) reject(line *Line, err error, fe *firstErr) {
+	var class = ClassConvert
+	if le, ok := err.(*LineError); ok {
+		class = le.Class
+		err = le.Err
+	}
+	p.rejects.add(class, 1)
+
+	var policy = p.Policy
+	if policy == nil {
+		policy = DefaultPolicy
+	}
+
+	switch policy.Action(class) {
+	case ActionFail:
+		fe.set(fmt.Errorf("%s:%d: %s: %v", line.Input, line.Num, class, err))
+		return
+	case ActionDeadLetter:
+		if p.DeadLetter != nil {
+			if err := p.DeadLetter.Reject(line, class, err); err != nil {
+				fe.set(err)
+				return
+			}
+		}
+	}
+
+	atomic.AddInt64(&p.skipLines, 1)
+}
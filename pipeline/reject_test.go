@@ -0,0 +1,160 @@
+package pipeline
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+//readDeadLetter returns the text of every record in a dead-letter file
+func readDeadLetter(t *testing.T, path string) []string {
+	t.Helper()
+
+	fp, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+
+	zr, err := gzip.NewReader(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var texts []string
+	var sc = bufio.NewScanner(zr)
+	for sc.Scan() {
+		var rec deadRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		texts = append(texts, rec.Text)
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+
+	return texts
+}
+
+func TestDeadLetterResume(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "rejected.jsonl.gz")
+	var reason = errors.New("bad")
+
+	var d = NewDeadLetterFile(path)
+	if err := d.Reject(&Line{Input: "in", Num: 1, Text: "a"}, ClassDecode, reason); err != nil {
+		t.Fatal(err)
+	}
+	sizes, err := d.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//rejected after the checkpoint, then the run is interrupted
+	d.Reject(&Line{Input: "in", Num: 2, Text: "b"}, ClassDecode, reason)
+	d.zw.Flush()
+	d.fp.Close()
+
+	var r = NewDeadLetterFile(path)
+	if err := r.Restore(sizes); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reject(&Line{Input: "in", Num: 2, Text: "c"}, ClassDecode, reason); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if texts := readDeadLetter(t, path); !reflect.DeepEqual(texts, []string{"a", "c"}) {
+		t.Fatalf("got %v", texts)
+	}
+}
+
+func TestDeadLetterRestoreWithoutRejects(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "rejected.jsonl.gz")
+	var d = NewDeadLetterFile(path)
+	d.Reject(&Line{Input: "in", Num: 1, Text: "a"}, ClassDecode, errors.New("bad"))
+	sizes, err := d.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.fp.Close()
+
+	//a restored file is published even if the resumed run rejects nothing
+	var r = NewDeadLetterFile(path)
+	if err := r.Restore(sizes); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if texts := readDeadLetter(t, path); !reflect.DeepEqual(texts, []string{"a"}) {
+		t.Fatalf("got %v", texts)
+	}
+}
+
+func TestDeadLetterRunFailsAndResumes(t *testing.T) {
+	var dir = t.TempDir()
+	var ck = filepath.Join(dir, "ck.json")
+	var in = filepath.Join(dir, "in.log")
+	var dead = filepath.Join(dir, "rejected.jsonl.gz")
+
+	var run = func() error {
+		cp, err := LoadCheckpoint(ck)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var p = New(NewFileSource([]string{in}, "auto", 1, cp.Inputs()), NewFileSink(dir, nil))
+		p.Workers = 1
+		p.Checkpoint = cp
+		p.CheckpointEvery = 4
+		p.Policy = Policy{ClassDecode: ActionDeadLetter}
+		p.DeadLetter = NewDeadLetterFile(dead)
+		return p.Run()
+	}
+
+	//g1 and g2 are committed with the checkpoint after line 4, g3 is not
+	writeFile(t, in, "g1\ng2\n"+logLine("a1")+logLine("a2")+"g3\n"+badLine)
+	if err := run(); err == nil {
+		t.Fatal("expected the bad line to fail the run")
+	}
+	for _, path := range []string{dead, filepath.Join(dir, "sdk-log-2017.12.01.csv.gz")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("%s published by a failed run: %v", path, err)
+		}
+	}
+
+	writeFile(t, in, "g1\ng2\n"+logLine("a1")+logLine("a2")+"g3\n"+logLine("a3"))
+	if err := run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if texts := readDeadLetter(t, dead); !reflect.DeepEqual(texts, []string{"g1", "g2", "g3"}) {
+		t.Fatalf("dead letters %v", texts)
+	}
+	if dids := devices(t, filepath.Join(dir, "sdk-log-2017.12.01.csv.gz")); !reflect.DeepEqual(dids, []string{"a1", "a2", "a3"}) {
+		t.Fatalf("rows %v", dids)
+	}
+}
+
+func TestDeadLetterAbortWithoutCheckpoint(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "rejected.jsonl.gz")
+	var d = NewDeadLetterFile(path)
+	d.Reject(&Line{Input: "in", Num: 1, Text: "a"}, ClassDecode, errors.New("bad"))
+	if err := d.Abort(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{path, tempPath(path)} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Fatalf("%s: %v", p, err)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/random9s/Analytics-Pipeline/log"
+)
+
+//Column types, they decide how values are written
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	//TypeTimeS is an epoch timestamp in seconds written as log.TimeLayout
+	TypeTimeS = "time_s"
+	//TypeTimeMS is an epoch timestamp in milliseconds written as log.TimeLayout
+	TypeTimeMS = "time_ms"
+)
+
+//Source namespaces a column can be read from
+const (
+	//SourceEvent reads an event key, e.g. event.fc
+	SourceEvent = "event"
+	//SourceURI reads a REQUEST_URI query parameter, matched case insensitively, e.g. uri.app
+	SourceURI = "uri"
+	//SourceLog reads a top level log key, e.g. log.REMOTE_ADDR
+	SourceLog = "log"
+	//SourceRequest reads a part of the request: request.path, or request.event_index,
+	//the position of the row's event among the events sent together
+	SourceRequest = "request"
+	//SourcePrefix reads the line prefix: prefix.time, prefix.source or prefix.level
+	SourcePrefix = "prefix"
+	//SourceExtras holds unknown event keys and request parameters as a json object, only extras.json exists
+	SourceExtras = "extras"
+)
+
+//Column is one output column. Source is namespace.key, any namespace not listed above
+//is filled by an Enricher, e.g. geo.city. Aliases are further keys in the same namespace
+//that are used, in order, when the key itself is missing.
+type Column struct {
+	Name    string   `json:"name"`
+	Source  string   `json:"source"`
+	Aliases []string `json:"aliases,omitempty"`
+	Type    string   `json:"type,omitempty"`
+	Default string   `json:"default,omitempty"`
+
+	ns   string
+	keys []string
+}
+
+//Schema is the ordered list of output columns
+type Schema struct {
+	Columns []Column `json:"columns"`
+
+	index    map[string]int
+	bySource map[string][]int
+	//refs holds every event key and request parameter a column reads, as namespace.key
+	refs map[string]bool
+}
+
+//LoadSchema reads a json schema file
+func LoadSchema(path string) (*Schema, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s = new(Schema)
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if err := s.init(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return s, nil
+}
+
+//NewSchema validates columns and builds a schema from them
+func NewSchema(columns []Column) (*Schema, error) {
+	var s = &Schema{Columns: columns}
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Schema) init() error {
+	if len(s.Columns) == 0 {
+		return fmt.Errorf("schema has no columns")
+	}
+
+	s.index = make(map[string]int, len(s.Columns))
+	s.bySource = make(map[string][]int)
+	s.refs = make(map[string]bool)
+
+	for i := range s.Columns {
+		var c = &s.Columns[i]
+		if c.Name == "" {
+			return fmt.Errorf("column %d has no name", i)
+		}
+		if _, dup := s.index[c.Name]; dup {
+			return fmt.Errorf("column %s declared twice", c.Name)
+		}
+
+		var dot = strings.IndexByte(c.Source, '.')
+		if dot <= 0 || dot == len(c.Source)-1 {
+			return fmt.Errorf("column %s: source %q is not namespace.key", c.Name, c.Source)
+		}
+		c.ns = c.Source[:dot]
+		c.keys = append([]string{c.Source[dot+1:]}, c.Aliases...)
+		if c.ns == SourceURI {
+			for k := range c.keys {
+				c.keys[k] = strings.ToLower(c.keys[k])
+			}
+		}
+
+		switch c.Type {
+		case "":
+			c.Type = TypeString
+		case TypeString, TypeInt, TypeFloat, TypeTimeS, TypeTimeMS:
+		default:
+			return fmt.Errorf("column %s: unknown type %q", c.Name, c.Type)
+		}
+
+		s.index[c.Name] = i
+		s.bySource[c.Source] = append(s.bySource[c.Source], i)
+		for _, k := range c.keys {
+			s.refs[c.ns+"."+k] = true
+		}
+	}
+
+	return nil
+}
+
+//Index returns the position of the named column or -1
+func (s *Schema) Index(name string) int {
+	i, ok := s.index[name]
+	if !ok {
+		return -1
+	}
+
+	return i
+}
+
+//Names returns the column names in order
+func (s *Schema) Names() []string {
+	var names = make([]string, len(s.Columns))
+	for i, c := range s.Columns {
+		names[i] = c.Name
+	}
+
+	return names
+}
+
+//format writes a looked up value according to the column type
+func (c *Column) format(v interface{}, loc *time.Location) string {
+	switch c.Type {
+	case TypeTimeS:
+		if f, ok := v.(float64); ok {
+			return log.Seconds(f).In(loc).Format(log.TimeLayout)
+		}
+	case TypeTimeMS:
+		if n, ok := v.(int64); ok && n >= 1000 {
+			return log.Millis(n).In(loc).Format(log.TimeLayout)
+		}
+	case TypeFloat:
+		if f, ok := v.(float64); ok && f != 0 {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+	}
+
+	switch x := v.(type) {
+	case string:
+		return x
+	case int64, float64:
+		return toString(x)
+	case bool:
+		return strconv.FormatBool(x)
+	case time.Time:
+		if !x.IsZero() {
+			return x.In(loc).Format(log.TimeLayout)
+		}
+		return ""
+	case nil:
+		return ""
+	}
+
+	//nested values of unknown keys
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+//DefaultSchema returns the built in schema
+func DefaultSchema() *Schema {
+	s, err := NewSchema(defaultColumns())
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+func defaultColumns() []Column {
+	return []Column{
+		{Name: "event_fc", Source: "event.fc", Type: TypeInt},
+		{Name: "http_user_agent", Source: "log.HTTP_USER_AGENT"},
+		{Name: "event_ori", Source: "event.ori"},
+		{Name: "event_uid", Source: "event.uid"},
+		{Name: "event_ord", Source: "event.ord"},
+		{Name: "uri_did", Source: "uri.did", Aliases: []string{"d"}},
+		{Name: "event_lc", Source: "event.lc", Type: TypeInt},
+		{Name: "event_lf", Source: "event.lf", Type: TypeInt},
+		{Name: "uri_l", Source: "uri.l"},
+		{Name: "event_dr", Source: "event.dr", Type: TypeInt},
+		{Name: "event_sp", Source: "event.sp"},
+		{Name: "uri_tz", Source: "uri.tz"},
+		{Name: "event_st", Source: "event.st"},
+		{Name: "remote_addr", Source: "log.REMOTE_ADDR"},
+		{Name: "uri_av", Source: "uri.av"},
+		{Name: "event_rid", Source: "event.rid"},
+		{Name: "uri_an", Source: "uri.an"},
+		{Name: "uri_app", Source: "uri.app"},
+		{Name: "request_time_float", Source: "log.REQUEST_TIME_FLOAT", Type: TypeTimeS},
+		{Name: "event_res", Source: "event.res", Type: TypeInt},
+		{Name: "uri_ov", Source: "uri.ov"},
+		{Name: "uri_os", Source: "uri.os"},
+		{Name: "event_typ", Source: "event.typ", Type: TypeInt},
+		{Name: "uri_kv", Source: "uri.kv"},
+		{Name: "event_ct", Source: "event.ct", Type: TypeInt},
+		{Name: "uri_sv", Source: "uri.sv", Aliases: []string{"v"}},
+		{Name: "client_id", Source: "log.CLIENT_ID"},
+		{Name: "request_uri", Source: "request.path"},
+		{Name: "event_vs", Source: "event.vs"},
+		{Name: "event_ps", Source: "event.ps"},
+		{Name: "event_ts", Source: "event.ts", Type: TypeTimeMS},
+		{Name: "event_n", Source: "event.n"},
+		{Name: "event_m", Source: "event.m"},
+		{Name: "event_tc", Source: "event.tc", Type: TypeInt},
+		{Name: "uri_dm", Source: "uri.dm", Aliases: []string{"dt"}},
+		{Name: "uri_fv", Source: "uri.fv", Aliases: []string{"p"}},
+		{Name: "event_tg", Source: "event.tg"},
+		{Name: "event_sn", Source: "event.sn"},
+		{Name: "uri_q", Source: "uri.q"},
+		{Name: "uri_uid", Source: "uri.uid"},
+		{Name: "uri_id", Source: "uri.id"},
+		{Name: "event_sc", Source: "event.sc"},
+		{Name: "geo_country", Source: "geo.country"},
+		{Name: "geo_city", Source: "geo.city"},
+		{Name: "event_index", Source: "request.event_index"},
+	}
+}
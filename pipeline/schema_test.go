@@ -0,0 +1,125 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/csv"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//convert decodes text and converts it with p
+func convert(t *testing.T, p *Pipeline, text string) []*Row {
+	t.Helper()
+
+	l, err := p.Decoder.Decode(&Line{Text: text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := p.Convert(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rows
+}
+
+func TestLoadSchema(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "columns.json")
+	writeFile(t, path, `{"columns": [
+		{"name": "did", "source": "uri.did", "aliases": ["D", "device"]},
+		{"name": "fc", "source": "event.fc", "type": "int"},
+		{"name": "ts", "source": "event.ts", "type": "time_ms"},
+		{"name": "city", "source": "geo.city", "default": "unknown"},
+		{"name": "n", "source": "event.n", "aliases": ["name"], "default": "none"}
+	]}`)
+
+	s, err := LoadSchema(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := s.Names(); !reflect.DeepEqual(names, []string{"did", "fc", "ts", "city", "n"}) {
+		t.Fatalf("names = %v", names)
+	}
+	//request parameters are matched case insensitively, a missing type is a string
+	if c := s.Columns[0]; !reflect.DeepEqual(c.keys, []string{"did", "d", "device"}) || c.Type != TypeString {
+		t.Fatalf("did = %+v", c)
+	}
+
+	var p = New(nil, nil)
+	p.Schema = s
+	for _, tc := range []struct {
+		text string
+		want []string
+	}{
+		//the column's own key wins over its aliases
+		{`{"REQUEST_TIME_FLOAT":1512161708,"REQUEST_URI":"/x?did=a&d=b","event":{"n":"open","name":"x","fc":3,"ts":1512161700000}}`,
+			[]string{"a", "3", "2017-12-01T20:55:00.000Z", "unknown", "open"}},
+		//aliases are tried in order, empty values take the default
+		{`{"REQUEST_TIME_FLOAT":1512161708,"REQUEST_URI":"/x?device=c&D=b","event":{"name":"close","fc":"4.5"}}`,
+			[]string{"b", "4", "", "unknown", "close"}},
+		//a key sent empty is not missing
+		{`{"REQUEST_TIME_FLOAT":1512161708,"REQUEST_URI":"/x?did=&d=b","event":{"n":"","name":"x"}}`,
+			[]string{"", "", "", "unknown", "none"}},
+		{`{"REQUEST_TIME_FLOAT":1512161708,"REQUEST_URI":"/x","event":{}}`,
+			[]string{"", "", "", "unknown", "none"}},
+	} {
+		if rows := convert(t, p, tc.text); !reflect.DeepEqual(rows[0].Fields, tc.want) {
+			t.Errorf("%s\n got %q\nwant %q", tc.text, rows[0].Fields, tc.want)
+		}
+	}
+}
+
+func TestLoadSchemaErrors(t *testing.T) {
+	for _, tc := range []struct {
+		json string
+		err  string
+	}{
+		{`{"columns": []}`, "no columns"},
+		{`{"columns": [{"source": "event.n"}]}`, "no name"},
+		{`{"columns": [{"name": "n", "source": "event.n"}, {"name": "n", "source": "event.m"}]}`, "declared twice"},
+		{`{"columns": [{"name": "n", "source": "n"}]}`, "not namespace.key"},
+		{`{"columns": [{"name": "n", "source": ".n"}]}`, "not namespace.key"},
+		{`{"columns": [{"name": "n", "source": "event."}]}`, "not namespace.key"},
+		{`{"columns": [{"name": "n", "source": ""}]}`, "not namespace.key"},
+		{`{"columns": [{"name": "n", "source": "event.n", "type": "date"}]}`, `unknown type "date"`},
+		{`{"columns": [{"name": "n", "source": "event.n", "type": "INT"}]}`, `unknown type "INT"`},
+		{`{"columns": {"name": "n"}}`, "cannot unmarshal"},
+	} {
+		var path = filepath.Join(t.TempDir(), "columns.json")
+		writeFile(t, path, tc.json)
+		if _, err := LoadSchema(path); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: error %v, want %q", tc.json, err, tc.err)
+		}
+	}
+
+	if _, err := LoadSchema(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loaded a missing file")
+	}
+}
+
+func TestConvertDefaultSchema(t *testing.T) {
+	const line = `[2017-12-01 20:55:08 ~ SDK ~ 0] {"REQUEST_TIME_FLOAT":1512161708.12,` +
+		`"REQUEST_URI":"/sdk/v1?d=dev1&l=en&tz=-5&av=2.1&app=foo&os=ios&V=3","REMOTE_ADDR":"1.2.3.4",` +
+		`"HTTP_USER_AGENT":"Mozilla/5.0","CLIENT_ID":"c1",` +
+		`"event":[{"n":"open","fc":"12","ts":1512161700000,"st":"a,b"},{"n":"close","dr":3.7}]}`
+
+	//one row per event, geo columns are left to the GeoEnricher
+	const want = `12,Mozilla/5.0,,,,dev1,,,en,,,-5,"a,b",1.2.3.4,2.1,,,foo,2017-12-01T20:55:08.120Z,,,ios,,,,3,c1,/sdk/v1,,,2017-12-01T20:55:00.000Z,open,,,,,,,,,,,,,0
+,Mozilla/5.0,,,,dev1,,,en,3,,-5,,1.2.3.4,2.1,,,foo,2017-12-01T20:55:08.120Z,,,ios,,,,3,c1,/sdk/v1,,,,close,,,,,,,,,,,,,1
+`
+
+	var buf bytes.Buffer
+	var w = csv.NewWriter(&buf)
+	for _, row := range convert(t, New(nil, nil), line) {
+		if err := w.Write(row.Fields); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Flush()
+
+	if buf.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,674 @@
+package pipeline
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//flush buffered rows to disk every flushN writes
+const flushN = 1000000
+
+//Modes deciding what happens to a partition file that already exists
+const (
+	//ModeReplace overwrites it with the rows of this run
+	ModeReplace = "replace"
+	//ModeMerge keeps its rows and adds the rows of this run after them,
+	//rows of an input the file already holds are skipped rather than merged again
+	ModeMerge = "merge"
+)
+
+//inputSet maps the absolute path of an input to its size and modification time, see FileSink.identity
+type inputSet map[string]string
+
+//manifest records the inputs whose rows a partition file holds, kept next to it as .<name>.inputs
+type manifest struct {
+	//Merged are inputs of earlier runs, their rows are not merged again
+	Merged inputSet `json:"merged"`
+	//Writing are the inputs of the current run, only kept for the temporary file of a checkpointed run
+	Writing inputSet `json:"writing,omitempty"`
+}
+
+type customWriter struct {
+	fp *os.File
+	zw *gzip.Writer
+	w  *csv.Writer
+	//dirty is set once rows were written since the last commit
+	dirty bool
+	//idle is set by Flush and cleared by Write
+	idle bool
+	rows int
+	//width is the number of fields of the rows in the file, 0 until this run writes one
+	width int
+	//full writers reached MaxRows, their file is complete and closed but not yet published
+	full bool
+	tmp  string
+	//merged and writing are the inputs of earlier runs and of this run whose rows the file holds
+	merged, writing inputSet
+}
+
+//FileSink writes rows to gzipped csv files named by a Namer, by default one file per day.
+//Files are written under a temporary name and only renamed into place once complete.
+type FileSink struct {
+	Dir   string
+	Namer Namer
+	//Mode is ModeReplace or ModeMerge
+	Mode string
+	//MaxRows, when set and the Namer uses a sequence number, starts a new file once one holds that many rows.
+	//Rows written before a checkpointed run was resumed are not counted.
+	//A new file is also started when rows get wider, e.g. as UnknownPromote adds columns, so each file has one width.
+	MaxRows int
+	//PublishIdle makes Flush publish partitions that received no rows since the previous Flush
+	PublishIdle bool
+
+	files     map[string]*customWriter
+	seqs      map[string]int
+	n         int
+	onOpen    func(path string, size int64) error
+	restored  map[string]bool
+	published map[string]*manifest
+	inputs    map[string][2]string
+	skipped   int64
+	replaced  int64
+
+	//partitions counts rows by file, relative to Dir, it is read while rows are written
+	mu         sync.Mutex
+	partitions map[string]int64
+	bytesOut   int64
+}
+
+//NewFileSink creates a sink writing files named by namer into dir, a nil namer uses DefaultTemplate
+func NewFileSink(dir string, namer Namer) *FileSink {
+	if namer == nil {
+		namer, _ = ParseTemplate(DefaultTemplate, DefaultSchema())
+	}
+
+	return &FileSink{
+		Dir:        dir,
+		Namer:      namer,
+		Mode:       ModeReplace,
+		files:      make(map[string]*customWriter),
+		seqs:       make(map[string]int),
+		restored:   make(map[string]bool),
+		published:  make(map[string]*manifest),
+		inputs:     make(map[string][2]string),
+		partitions: make(map[string]int64),
+	}
+}
+
+//tempPath is where the partition at path is written until it is complete
+func tempPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+}
+
+//finalPath reverses tempPath
+func finalPath(tmp string) string {
+	var base = strings.TrimSuffix(strings.TrimPrefix(filepath.Base(tmp), "."), ".tmp")
+	return filepath.Join(filepath.Dir(tmp), base)
+}
+
+//manifestPath is where the manifest of the partition at path is kept
+func manifestPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".inputs")
+}
+
+func readManifest(path string) (*manifest, error) {
+	var m manifest
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	if m.Merged == nil {
+		m.Merged = make(inputSet)
+	}
+	if m.Writing == nil {
+		m.Writing = make(inputSet)
+	}
+
+	return &m, nil
+}
+
+//writeManifest replaces the manifest at path in one rename
+func writeManifest(path string, m *manifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".new", b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".new", path)
+}
+
+//identity names a regular file input by its absolute path and tells its contents apart by size and
+//modification time. Other inputs, such as stdin, get an empty name and are not tracked.
+func (s *FileSink) identity(input string) (string, string) {
+	id, ok := s.inputs[input]
+	if !ok {
+		if fi, err := os.Stat(input); err == nil && fi.Mode().IsRegular() {
+			if abs, err := filepath.Abs(input); err == nil {
+				id = [2]string{abs, fmt.Sprintf("%d %d", fi.Size(), fi.ModTime().UnixNano())}
+			}
+		}
+		s.inputs[input] = id
+	}
+
+	return id[0], id[1]
+}
+
+//Produces reports whether path is a file the sink could write, published or temporary, so that it is not read
+//back as input. Only a Namer with a Matches(name string) bool method, such as a Template, can tell.
+func (s *FileSink) Produces(path string) bool {
+	m, ok := s.Namer.(interface{ Matches(name string) bool })
+	if !ok {
+		return false
+	}
+
+	dir, err := filepath.Abs(s.Dir)
+	if err != nil {
+		return false
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+
+	//temporary files and manifests are hidden and suffixed
+	if base := filepath.Base(rel); strings.HasPrefix(base, ".") {
+		var name = strings.TrimSuffix(strings.TrimSuffix(base, ".inputs"), ".tmp")
+		if name != base {
+			rel = filepath.Join(filepath.Dir(rel), name[1:])
+		}
+	}
+	rel = filepath.ToSlash(rel)
+	for _, b := range []string{BucketLate, BucketFuture} {
+		if strings.HasPrefix(rel, b+"/") && m.Matches(rel[len(b)+1:]) {
+			return true
+		}
+	}
+
+	return m.Matches(rel)
+}
+
+//Write ...
+func (s *FileSink) Write(row *Row) error {
+	var outfile = s.path(row)
+
+	cw, exists := s.files[outfile]
+	if exists && !cw.full && cw.width > 0 && cw.width != len(row.Fields) {
+		if !s.Namer.Sequenced() {
+			return fmt.Errorf("%s: rows changed from %d to %d columns, the name template needs {seq} to start a new file", outfile, cw.width, len(row.Fields))
+		}
+		if err := s.finish(cw); err != nil {
+			return err
+		}
+	}
+	for exists && cw.full {
+		//start the next file of the partition
+		s.seqs[s.group(row)]++
+		outfile = s.path(row)
+		cw, exists = s.files[outfile]
+	}
+
+	if !exists {
+		var err error
+		cw, err = s.open(outfile)
+		if err != nil {
+			return err
+		}
+		//store for later use
+		s.files[outfile] = cw
+	}
+
+	if input, id := s.identity(row.Input); input != "" {
+		if prev, ok := cw.merged[input]; ok {
+			if prev != id {
+				return fmt.Errorf("%s was merged into %s before and has changed since, merging it again would duplicate its rows, use -mode replace", row.Input, outfile)
+			}
+			atomic.AddInt64(&s.skipped, 1)
+			return nil
+		}
+		cw.writing[input] = id
+	}
+
+	if err := cw.w.Write(row.Fields); err != nil {
+		return err
+	}
+	cw.dirty = true
+	cw.idle = false
+	cw.rows++
+	cw.width = len(row.Fields)
+	s.n++
+
+	if rel, err := filepath.Rel(s.Dir, outfile); err == nil {
+		s.mu.Lock()
+		s.partitions[rel]++
+		s.mu.Unlock()
+	}
+
+	if s.MaxRows > 0 && cw.rows >= s.MaxRows && s.Namer.Sequenced() {
+		if err := s.finish(cw); err != nil {
+			return err
+		}
+		//move on now rather than with the next row, PublishIdle may publish and forget the file before then
+		s.seqs[s.group(row)]++
+	}
+
+	//batch records to write to disk
+	if s.n%flushN == 0 {
+		return s.flush()
+	}
+
+	return nil
+}
+
+//group names a row's partition regardless of sequence number
+func (s *FileSink) group(row *Row) string {
+	return s.name(row, -1)
+}
+
+//name places rows routed to a bucket in a directory of that name
+func (s *FileSink) name(row *Row, seq int) string {
+	return filepath.Join(s.Dir, row.Bucket, s.Namer.Name(row, seq))
+}
+
+func (s *FileSink) path(row *Row) string {
+	if !s.Namer.Sequenced() {
+		return s.name(row, 0)
+	}
+
+	var key = s.group(row)
+	seq, ok := s.seqs[key]
+	if !ok {
+		//continue after files restored from a checkpoint
+		for s.files[s.name(row, seq+1)] != nil {
+			seq++
+		}
+		s.seqs[key] = seq
+	}
+
+	return s.name(row, seq)
+}
+
+//finish completes and closes a writer's file, leaving it to be published
+func (s *FileSink) finish(v *customWriter) error {
+	//final buffer flush, a committed file already ends with a complete member
+	if v.dirty {
+		v.w.Flush()
+		if err := v.w.Error(); err != nil {
+			return err
+		}
+		if err := v.zw.Close(); err != nil {
+			return err
+		}
+	}
+	if err := v.fp.Sync(); err != nil {
+		return err
+	}
+
+	v.full = true
+	v.dirty = false
+	return v.fp.Close()
+}
+
+//open creates the temporary file for a partition and wraps it with a gzipped csv writer
+func (s *FileSink) open(path string) (*customWriter, error) {
+	var tmp = tempPath(path)
+	if err := os.MkdirAll(filepath.Dir(tmp), 0755); err != nil {
+		return nil, err
+	}
+
+	//a temporary file is only continued when a checkpoint vouches for its contents
+	var flags = os.O_APPEND | os.O_RDWR | os.O_CREATE
+	if !s.restored[tmp] {
+		flags |= os.O_TRUNC
+	}
+
+	fp, err := os.OpenFile(tmp, flags, 0766)
+	if err != nil {
+		return nil, err
+	}
+
+	//rows of an existing partition are copied over as they are, gzip members can simply be concatenated
+	var m = &manifest{Merged: make(inputSet), Writing: make(inputSet)}
+	switch {
+	case s.restored[tmp]:
+		m, err = readManifest(tmp + ".inputs")
+	case s.published[path] != nil:
+		//a partition published earlier in this run is reopened
+		err = copyFile(fp, path)
+		m = &manifest{Merged: copySet(s.published[path].Merged), Writing: copySet(s.published[path].Writing)}
+	case s.Mode == ModeMerge:
+		if _, statErr := os.Stat(path); statErr == nil {
+			err = copyFile(fp, path)
+			if err == nil {
+				m, err = readManifest(manifestPath(path))
+			}
+		}
+	}
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+
+	//a restored file is already recorded at its committed size,
+	//a new one gets its manifest now as Commit only rewrites it once rows were written
+	if s.onOpen != nil && !s.restored[tmp] {
+		fi, err := fp.Stat()
+		if err == nil {
+			err = writeManifest(tmp+".inputs", m)
+		}
+		if err == nil {
+			err = s.onOpen(tmp, fi.Size())
+		}
+		if err != nil {
+			fp.Close()
+			return nil, err
+		}
+	}
+
+	zw := gzip.NewWriter(fp)
+	w := csv.NewWriter(zw)
+	//create new custom writer
+	return &customWriter{
+		fp:      fp,
+		zw:      zw,
+		w:       w,
+		tmp:     tmp,
+		merged:  m.Merged,
+		writing: m.Writing,
+	}, nil
+}
+
+func copySet(set inputSet) inputSet {
+	var c = make(inputSet, len(set))
+	for k, v := range set {
+		c[k] = v
+	}
+
+	return c
+}
+
+func copyFile(w io.Writer, path string) error {
+	fp, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	_, err = io.Copy(w, fp)
+	return err
+}
+
+//Flush writes buffered rows of every open file through to disk
+func (s *FileSink) Flush() error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+	if !s.PublishIdle {
+		return nil
+	}
+
+	for path, v := range s.files {
+		if !v.idle {
+			v.idle = true
+			continue
+		}
+
+		if err := s.publish(path, v); err != nil {
+			return err
+		}
+		delete(s.files, path)
+	}
+
+	return nil
+}
+
+func (s *FileSink) flush() error {
+	for _, v := range s.files {
+		//a writer reset by Commit or reopened by Restore has not started a gzip member yet,
+		//flushing it would write a header that finish never closes
+		if v.full || !v.dirty {
+			continue
+		}
+
+		v.w.Flush()
+		if err := v.w.Error(); err != nil {
+			return err
+		}
+		if err := v.zw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//Commit ends the current gzip member of every file written to and syncs it to disk,
+//files are left valid multi-member gzip streams that later rows are appended to
+func (s *FileSink) Commit() (map[string]int64, error) {
+	var sizes = make(map[string]int64, len(s.files))
+	for _, v := range s.files {
+		if v.dirty {
+			v.w.Flush()
+			if err := v.w.Error(); err != nil {
+				return nil, err
+			}
+			if err := v.zw.Close(); err != nil {
+				return nil, err
+			}
+			if err := v.fp.Sync(); err != nil {
+				return nil, err
+			}
+			v.zw.Reset(v.fp)
+			v.dirty = false
+
+			//a resumed run needs to know which inputs the committed rows came from
+			if err := writeManifest(v.tmp+".inputs", &manifest{v.merged, v.writing}); err != nil {
+				return nil, err
+			}
+		}
+
+		fi, err := os.Stat(v.tmp)
+		if err != nil {
+			return nil, err
+		}
+		sizes[v.tmp] = fi.Size()
+	}
+
+	return sizes, nil
+}
+
+//Restore truncates temporary files back to their committed sizes and reopens them,
+//so that they are published by Close even if no further rows arrive. Files that were empty are removed.
+func (s *FileSink) Restore(sizes map[string]int64) error {
+	for tmp, size := range sizes {
+		if size == 0 {
+			for _, f := range []string{tmp, tmp + ".inputs"} {
+				if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+			continue
+		}
+
+		fi, err := os.Stat(tmp)
+		if os.IsNotExist(err) {
+			//already published
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		//truncating would pad the file with zeros rather than give back what was committed
+		if fi.Size() < size {
+			return fmt.Errorf("%s: %d bytes, smaller than the %d the checkpoint committed", tmp, fi.Size(), size)
+		}
+		if err := os.Truncate(tmp, size); err != nil {
+			return err
+		}
+
+		s.restored[tmp] = true
+		cw, err := s.open(finalPath(tmp))
+		if err != nil {
+			return err
+		}
+		s.files[finalPath(tmp)] = cw
+	}
+
+	return nil
+}
+
+//Partitions returns the number of rows written to each file this run, by path relative to Dir
+func (s *FileSink) Partitions() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var m = make(map[string]int64, len(s.partitions))
+	for k, n := range s.partitions {
+		m[k] = n
+	}
+
+	return m
+}
+
+//Skipped returns the number of rows dropped because the file they belong to already holds their input
+func (s *FileSink) Skipped() int64 {
+	return atomic.LoadInt64(&s.skipped)
+}
+
+//Replaced returns the number of files that existed before the run and were overwritten by ModeReplace
+func (s *FileSink) Replaced() int64 {
+	return atomic.LoadInt64(&s.replaced)
+}
+
+//BytesWritten returns the size of the files published so far
+func (s *FileSink) BytesWritten() int64 {
+	return atomic.LoadInt64(&s.bytesOut)
+}
+
+//OnOpen ...
+func (s *FileSink) OnOpen(fn func(path string, size int64) error) {
+	s.onOpen = fn
+}
+
+//publish completes the temporary file of a partition and renames it into place
+func (s *FileSink) publish(path string, v *customWriter) error {
+	if !v.full {
+		if err := s.finish(v); err != nil {
+			v.fp.Close()
+			return err
+		}
+	}
+
+	if fi, err := os.Stat(v.tmp); err == nil {
+		atomic.AddInt64(&s.bytesOut, fi.Size())
+	}
+	if _, err := os.Stat(path); err == nil && s.Mode == ModeReplace && s.published[path] == nil {
+		atomic.AddInt64(&s.replaced, 1)
+	}
+
+	if err := os.Rename(v.tmp, path); err != nil {
+		return err
+	}
+	//a file opened for path again starts a new temporary file
+	delete(s.restored, v.tmp)
+	s.published[path] = &manifest{Merged: v.merged, Writing: v.writing}
+
+	//the rows are in place before the manifest says so, a crash in between duplicates rows on a rerun rather than losing them
+	var all = copySet(v.merged)
+	for k, id := range v.writing {
+		all[k] = id
+	}
+	if err := writeManifest(manifestPath(path), &manifest{Merged: all}); err != nil {
+		return err
+	}
+	if err := os.Remove(v.tmp + ".inputs"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+func syncDir(dir string) error {
+	fp, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	return fp.Sync()
+}
+
+//Close publishes every open file
+func (s *FileSink) Close() error {
+	var firstErr error
+	for path, v := range s.files {
+		if err := s.publish(path, v); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(s.files, path)
+	}
+
+	return firstErr
+}
+
+//Abort closes every open file without publishing it. Temporary files are kept
+//for a checkpointed run to resume from, otherwise they are removed.
+func (s *FileSink) Abort() error {
+	var firstErr error
+	for path, v := range s.files {
+		var err error
+		if !v.full {
+			err = v.fp.Close()
+		}
+		if s.onOpen == nil {
+			if rmErr := os.Remove(v.tmp); err == nil {
+				err = rmErr
+			}
+			if rmErr := os.Remove(v.tmp + ".inputs"); err == nil && !os.IsNotExist(rmErr) {
+				err = rmErr
+			}
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(s.files, path)
+	}
+
+	return firstErr
+}
+
+//DiscardSink drops every row, for runs that only inspect the input
+type DiscardSink struct{}
+
+//Write ...
+func (DiscardSink) Write(row *Row) error { return nil }
+
+//Flush ...
+func (DiscardSink) Flush() error { return nil }
+
+//Close ...
+func (DiscardSink) Close() error { return nil }
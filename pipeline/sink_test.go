@@ -0,0 +1,396 @@
+package pipeline
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var day1 = time.Date(2017, 12, 1, 20, 0, 0, 0, time.UTC)
+var day2 = day1.Add(24 * time.Hour)
+
+func testRow(t time.Time, fields ...string) *Row {
+	return &Row{Fields: fields, Time: t}
+}
+
+//readGzipCSV reads every record of a gzipped csv file, failing on a truncated stream
+func readGzipCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+
+	fp, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+
+	zr, err := gzip.NewReader(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs, err := csv.NewReader(zr).ReadAll()
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+
+	return recs
+}
+
+func TestFileSinkFlushAfterCommit(t *testing.T) {
+	var dir = t.TempDir()
+	var s = NewFileSink(dir, nil)
+	s.OnOpen(func(string, int64) error { return nil })
+
+	if err := s.Write(testRow(day1, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	//the day1 file gets no rows after the commit, flushing it must not start a member
+	if err := s.Write(testRow(day2, "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if recs := readGzipCSV(t, filepath.Join(dir, "sdk-log-2017.12.01.csv.gz")); !reflect.DeepEqual(recs, [][]string{{"a"}}) {
+		t.Fatalf("day1 = %v", recs)
+	}
+	if recs := readGzipCSV(t, filepath.Join(dir, "sdk-log-2017.12.02.csv.gz")); !reflect.DeepEqual(recs, [][]string{{"b"}}) {
+		t.Fatalf("day2 = %v", recs)
+	}
+}
+
+func TestFileSinkFlushAfterRestore(t *testing.T) {
+	var dir = t.TempDir()
+	var s = NewFileSink(dir, nil)
+	s.OnOpen(func(string, int64) error { return nil })
+	s.Write(testRow(day1, "a"))
+	sizes, err := s.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Abort()
+
+	var r = NewFileSink(dir, nil)
+	r.OnOpen(func(string, int64) error { return nil })
+	if err := r.Restore(sizes); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if recs := readGzipCSV(t, filepath.Join(dir, "sdk-log-2017.12.01.csv.gz")); !reflect.DeepEqual(recs, [][]string{{"a"}}) {
+		t.Fatalf("got %v", recs)
+	}
+}
+
+func TestFileSinkNewFileWhenRowsWiden(t *testing.T) {
+	var dir = t.TempDir()
+	namer, err := ParseTemplate("{date}-{seq}.csv.gz", DefaultSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s = NewFileSink(dir, namer)
+	s.OnOpen(func(string, int64) error { return nil })
+
+	for _, row := range []*Row{testRow(day1, "a"), testRow(day1, "b"), testRow(day1, "c", "x"), testRow(day1, "d", "y")} {
+		if err := s.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if recs := readGzipCSV(t, filepath.Join(dir, "2017.12.01-0000.csv.gz")); !reflect.DeepEqual(recs, [][]string{{"a"}, {"b"}}) {
+		t.Fatalf("seq 0 = %v", recs)
+	}
+	if recs := readGzipCSV(t, filepath.Join(dir, "2017.12.01-0001.csv.gz")); !reflect.DeepEqual(recs, [][]string{{"c", "x"}, {"d", "y"}}) {
+		t.Fatalf("seq 1 = %v", recs)
+	}
+}
+
+func TestFileSinkWidenUnsequenced(t *testing.T) {
+	var s = NewFileSink(t.TempDir(), nil)
+	s.OnOpen(func(string, int64) error { return nil })
+	defer s.Abort()
+
+	if err := s.Write(testRow(day1, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(testRow(day1, "b", "x")); err == nil {
+		t.Fatal("widening a file without {seq} should fail")
+	}
+}
+
+func TestFileSinkPublishIdleFullFile(t *testing.T) {
+	var dir = t.TempDir()
+	namer, err := ParseTemplate("{date}-{seq}.csv.gz", DefaultSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s = NewFileSink(dir, namer)
+	s.MaxRows = 2
+	s.PublishIdle = true
+
+	s.Write(testRow(day1, "a"))
+	s.Write(testRow(day1, "b"))
+	//the full file goes idle and is published
+	for i := 0; i < 2; i++ {
+		if err := s.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Write(testRow(day1, "c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if recs := readGzipCSV(t, filepath.Join(dir, "2017.12.01-0000.csv.gz")); !reflect.DeepEqual(recs, [][]string{{"a"}, {"b"}}) {
+		t.Fatalf("seq 0 = %v", recs)
+	}
+	if recs := readGzipCSV(t, filepath.Join(dir, "2017.12.01-0001.csv.gz")); !reflect.DeepEqual(recs, [][]string{{"c"}}) {
+		t.Fatalf("seq 1 = %v", recs)
+	}
+}
+
+func TestFileSinkResume(t *testing.T) {
+	var dir = t.TempDir()
+	var s = NewFileSink(dir, nil)
+	var opened = make(map[string]int64)
+	s.OnOpen(func(path string, size int64) error {
+		opened[path] = size
+		return nil
+	})
+
+	s.Write(testRow(day1, "a"))
+	sizes, err := s.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//written after the checkpoint, then the run fails
+	s.Write(testRow(day1, "b"))
+	s.Write(testRow(day2, "x"))
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	//the failed run recorded every file before writing to it
+	for path, size := range opened {
+		if _, ok := sizes[path]; !ok {
+			sizes[path] = size
+		}
+	}
+
+	var r = NewFileSink(dir, nil)
+	r.OnOpen(func(string, int64) error { return nil })
+	if err := r.Restore(sizes); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Write(testRow(day1, "c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if recs := readGzipCSV(t, filepath.Join(dir, "sdk-log-2017.12.01.csv.gz")); !reflect.DeepEqual(recs, [][]string{{"a"}, {"c"}}) {
+		t.Fatalf("day1 = %v", recs)
+	}
+	//a file that only held uncommitted rows is dropped
+	for _, name := range []string{"sdk-log-2017.12.02.csv.gz", ".sdk-log-2017.12.02.csv.gz.tmp"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestFileSinkPublish(t *testing.T) {
+	var dir = t.TempDir()
+	var s = NewFileSink(dir, nil)
+	s.Write(testRow(day1, "a"))
+
+	//nothing is visible until Close
+	if _, err := os.Stat(filepath.Join(dir, "sdk-log-2017.12.01.csv.gz")); !os.IsNotExist(err) {
+		t.Fatalf("published before Close: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".sdk-log-2017.12.01.csv.gz.tmp")); !os.IsNotExist(err) {
+		t.Fatalf("temporary file left behind: %v", err)
+	}
+
+	//merge keeps the published rows, replace drops them
+	for mode, want := range map[string][][]string{
+		ModeMerge:   {{"a"}, {"b"}},
+		ModeReplace: {{"b"}},
+	} {
+		var dir = t.TempDir()
+		for _, v := range []string{"a", "b"} {
+			var s = NewFileSink(dir, nil)
+			s.Mode = mode
+			s.Write(testRow(day1, v))
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if recs := readGzipCSV(t, filepath.Join(dir, "sdk-log-2017.12.01.csv.gz")); !reflect.DeepEqual(recs, want) {
+			t.Fatalf("%s = %v", mode, recs)
+		}
+	}
+}
+
+func TestFileSinkAbort(t *testing.T) {
+	var dir = t.TempDir()
+	var s = NewFileSink(dir, nil)
+	s.Write(testRow(day1, "a"))
+	if err := s.Abort(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("left behind %v", entries)
+	}
+}
+
+//writeInputs writes one row per input to day1 and closes the sink
+func writeInputs(s *FileSink, inputs ...string) error {
+	for _, in := range inputs {
+		var row = testRow(day1, filepath.Base(in))
+		row.Input = in
+		if err := s.Write(row); err != nil {
+			s.Abort()
+			return err
+		}
+	}
+
+	return s.Close()
+}
+
+func TestFileSinkMergeSkipsMergedInputs(t *testing.T) {
+	var dir, in = t.TempDir(), t.TempDir()
+	var a, b = filepath.Join(in, "a.log"), filepath.Join(in, "b.log")
+	writeFile(t, a, "a\n")
+	writeFile(t, b, "b\n")
+	var day = filepath.Join(dir, "sdk-log-2017.12.01.csv.gz")
+
+	var merge = func(want [][]string, skipped int64, inputs ...string) {
+		t.Helper()
+
+		var s = NewFileSink(dir, nil)
+		s.Mode = ModeMerge
+		if err := writeInputs(s, inputs...); err != nil {
+			t.Fatal(err)
+		}
+		if recs := readGzipCSV(t, day); !reflect.DeepEqual(recs, want) {
+			t.Fatalf("merging %v: %v", inputs, recs)
+		}
+		if s.Skipped() != skipped {
+			t.Fatalf("merging %v: skipped %d, want %d", inputs, s.Skipped(), skipped)
+		}
+	}
+
+	merge([][]string{{"a.log"}}, 0, a)
+	merge([][]string{{"a.log"}}, 1, a)
+	merge([][]string{{"a.log"}, {"b.log"}}, 1, a, b)
+
+	//a changed input cannot be merged again without duplicating its earlier rows
+	writeFile(t, a, "a\nmore\n")
+	var s = NewFileSink(dir, nil)
+	s.Mode = ModeMerge
+	if err := writeInputs(s, a); err == nil {
+		t.Fatal("merged a changed input")
+	}
+	if recs := readGzipCSV(t, day); !reflect.DeepEqual(recs, [][]string{{"a.log"}, {"b.log"}}) {
+		t.Fatalf("failed merge changed the file: %v", recs)
+	}
+
+	//replace starts the manifest over
+	s = NewFileSink(dir, nil)
+	if err := writeInputs(s, a); err != nil {
+		t.Fatal(err)
+	}
+	if s.Replaced() != 1 {
+		t.Fatalf("replaced = %d, want 1", s.Replaced())
+	}
+	merge([][]string{{"a.log"}, {"b.log"}}, 1, a, b)
+}
+
+func TestFileSinkMergeResume(t *testing.T) {
+	var dir, in = t.TempDir(), t.TempDir()
+	var a, b, c = filepath.Join(in, "a.log"), filepath.Join(in, "b.log"), filepath.Join(in, "c.log")
+	for _, f := range []string{a, b, c} {
+		writeFile(t, f, f+"\n")
+	}
+	var s = NewFileSink(dir, nil)
+	s.Mode = ModeMerge
+	if err := writeInputs(s, a); err != nil {
+		t.Fatal(err)
+	}
+
+	//a checkpointed merge commits rows of b, then fails
+	s = NewFileSink(dir, nil)
+	s.Mode = ModeMerge
+	s.OnOpen(func(string, int64) error { return nil })
+	var row = testRow(day1, "b.log")
+	row.Input = b
+	if err := s.Write(row); err != nil {
+		t.Fatal(err)
+	}
+	sizes, err := s.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Abort(); err != nil {
+		t.Fatal(err)
+	}
+
+	//the resumed run still knows the file held a before it
+	var r = NewFileSink(dir, nil)
+	r.Mode = ModeMerge
+	r.OnOpen(func(string, int64) error { return nil })
+	if err := r.Restore(sizes); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeInputs(r, a, c); err != nil {
+		t.Fatal(err)
+	}
+	var want = [][]string{{"a.log"}, {"b.log"}, {"c.log"}}
+	if recs := readGzipCSV(t, filepath.Join(dir, "sdk-log-2017.12.01.csv.gz")); !reflect.DeepEqual(recs, want) {
+		t.Fatalf("resumed merge = %v", recs)
+	}
+
+	//b counts as merged once the resumed run published it
+	s = NewFileSink(dir, nil)
+	s.Mode = ModeMerge
+	if err := writeInputs(s, a, b, c); err != nil {
+		t.Fatal(err)
+	}
+	if recs := readGzipCSV(t, filepath.Join(dir, "sdk-log-2017.12.01.csv.gz")); !reflect.DeepEqual(recs, want) {
+		t.Fatalf("rerun = %v", recs)
+	}
+}
@@ -0,0 +1,55 @@
+package pipeline
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+)
+
+//ReaderSource reads newline separated lines from an io.Reader
+type ReaderSource struct {
+	name   string
+	r      *bufio.Reader
+	num    int64
+	offset int64
+}
+
+//NewReaderSource wraps r, name is recorded on every line read
+func NewReaderSource(name string, r io.Reader) *ReaderSource {
+	return &ReaderSource{
+		name: name,
+		r:    bufio.NewReader(r),
+	}
+}
+
+//Next ...
+func (s *ReaderSource) Next() (*Line, error) {
+	line, err := s.r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.num++
+	s.offset += int64(len(line))
+	return &Line{
+		Text:   line,
+		Input:  s.name,
+		Num:    s.num,
+		Offset: s.offset,
+	}, nil
+}
+
+//Skip discards input up to a position recorded by a checkpoint
+func (s *ReaderSource) Skip(st InputState) error {
+	n, err := io.CopyN(ioutil.Discard, s.r, st.Offset)
+	s.offset += n
+	if err != nil {
+		return err
+	}
+
+	s.num += st.Lines
+	return nil
+}
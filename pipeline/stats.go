@@ -0,0 +1,168 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//ByteReader is implemented by sources that count the bytes they read before decompression
+type ByteReader interface {
+	BytesRead() int64
+}
+
+//PartitionWriter is implemented by sinks that count rows per output partition and bytes written
+type PartitionWriter interface {
+	Partitions() map[string]int64
+	BytesWritten() int64
+}
+
+//CacheStater is implemented by enrichers backed by a cache
+type CacheStater interface {
+	CacheStats() (hits, misses, evictions int64)
+}
+
+//CacheStats sums up the caches of the enrichers
+type CacheStats struct {
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	Evictions int64   `json:"evictions"`
+	HitRate   float64 `json:"hit_rate"`
+}
+
+//Stats is a report of what a run did
+type Stats struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration float64   `json:"duration_seconds"`
+
+	LinesRead     int64 `json:"lines_read"`
+	RowsWritten   int64 `json:"rows_written"`
+	LinesSkipped  int64 `json:"lines_skipped"`
+	LinesFiltered int64 `json:"lines_filtered"`
+
+	//Rejected counts rejected lines by error class
+	Rejected map[string]int64 `json:"rejected"`
+	//Coerced counts converted event values by key:type
+	Coerced map[string]int64 `json:"coerced"`
+	//Partitions counts rows by output file
+	Partitions map[string]int64 `json:"partitions"`
+	//Clients counts rows by client_id, Events by event name
+	Clients map[string]int64 `json:"clients"`
+	Events  map[string]int64 `json:"events"`
+
+	Cache *CacheStats `json:"cache,omitempty"`
+
+	//BytesRead is read from the inputs before decompression, BytesIn after it.
+	//BytesOut is the size of the published outputs.
+	BytesRead int64 `json:"bytes_read"`
+	BytesIn   int64 `json:"bytes_in"`
+	BytesOut  int64 `json:"bytes_out"`
+
+	LinesPerSecond float64 `json:"lines_per_second"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+}
+
+//Stats reports on the run so far, or on the whole run once Run returned
+func (p *Pipeline) Stats() *Stats {
+	var st = &Stats{
+		Start:         p.start,
+		End:           p.end,
+		LinesRead:     atomic.LoadInt64(&p.readLines),
+		RowsWritten:   atomic.LoadInt64(&p.writeLines),
+		LinesSkipped:  atomic.LoadInt64(&p.skipLines),
+		LinesFiltered: atomic.LoadInt64(&p.filterLines),
+		Rejected:      p.rejects.snapshot(),
+		Coerced:       p.coercions.snapshot(),
+		Clients:       p.clients.snapshot(),
+		Events:        p.events.snapshot(),
+		BytesIn:       atomic.LoadInt64(&p.bytesIn),
+	}
+	if st.End.IsZero() {
+		st.End = time.Now()
+	}
+	st.Duration = st.End.Sub(st.Start).Seconds()
+
+	if b, ok := p.Source.(ByteReader); ok {
+		st.BytesRead = b.BytesRead()
+	}
+
+	if pw, ok := p.Sink.(PartitionWriter); ok {
+		st.Partitions = pw.Partitions()
+		st.BytesOut = pw.BytesWritten()
+	}
+
+	for _, en := range p.Enrichers {
+		if c, ok := en.(CacheStater); ok {
+			if st.Cache == nil {
+				st.Cache = new(CacheStats)
+			}
+			var hits, misses, evictions = c.CacheStats()
+			st.Cache.Hits += hits
+			st.Cache.Misses += misses
+			st.Cache.Evictions += evictions
+		}
+	}
+	if st.Cache != nil && st.Cache.Hits+st.Cache.Misses > 0 {
+		st.Cache.HitRate = float64(st.Cache.Hits) / float64(st.Cache.Hits+st.Cache.Misses)
+	}
+
+	if st.Duration > 0 {
+		st.LinesPerSecond = float64(st.LinesRead) / st.Duration
+		st.BytesPerSecond = float64(st.BytesIn) / st.Duration
+	}
+
+	return st
+}
+
+//WriteText writes the report in a human readable form
+func (st *Stats) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Run took %s (%s to %s)\n", time.Duration(st.Duration*float64(time.Second)).Round(time.Millisecond),
+		st.Start.Format(time.RFC3339), st.End.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Lines read %d, rows written %d, skipped %d, filtered %d\n", st.LinesRead, st.RowsWritten, st.LinesSkipped, st.LinesFiltered)
+	fmt.Fprintf(&b, "Bytes read %d, decompressed %d, written %d\n", st.BytesRead, st.BytesIn, st.BytesOut)
+	fmt.Fprintf(&b, "Throughput %.0f lines/s, %.0f bytes/s\n", st.LinesPerSecond, st.BytesPerSecond)
+	if st.Cache != nil {
+		fmt.Fprintf(&b, "Cache hits %d, misses %d, evictions %d, hit rate %.1f%%\n", st.Cache.Hits, st.Cache.Misses, st.Cache.Evictions, st.Cache.HitRate*100)
+	}
+
+	writeCounts(&b, "Rejected by class", st.Rejected)
+	writeCounts(&b, "Coerced values", st.Coerced)
+	writeCounts(&b, "Rows by partition", st.Partitions)
+	writeCounts(&b, "Rows by client_id", st.Clients)
+	writeCounts(&b, "Rows by event name", st.Events)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+//writeCounts lists counts under a title, most frequent first
+func writeCounts(b *strings.Builder, title string, counts map[string]int64) {
+	if len(counts) == 0 {
+		return
+	}
+
+	var keys = make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	fmt.Fprintf(b, "\n%s:\n", title)
+	for _, k := range keys {
+		var name = k
+		if name == "" {
+			name = "(none)"
+		}
+		fmt.Fprintf(b, "  %-40s %d\n", name, counts[k])
+	}
+}
@@ -0,0 +1,180 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/random9s/Analytics-Pipeline/log"
+)
+
+//Modes deciding what happens to event keys and request parameters no column reads
+const (
+	//UnknownIgnore drops them
+	UnknownIgnore = "ignore"
+	//UnknownExtras writes them as a json object to the columns read from extras.json
+	UnknownExtras = "extras"
+	//UnknownPromote appends a column for each of them to the schema
+	UnknownPromote = "promote"
+	//UnknownReport only counts them, see UnknownKeys
+	UnknownReport = "report"
+)
+
+//unknownKey is an unmapped event key or request parameter
+type unknownKey struct {
+	ns, key string
+	v       interface{}
+}
+
+//name is the column an unknown key is promoted to, e.g. event_foo or uri_bar
+func (k unknownKey) name() string {
+	return k.ns + "_" + k.key
+}
+
+//unknown keeps track of unmapped keys seen by the workers
+type unknown struct {
+	mu     sync.RWMutex
+	counts counter
+	//schema, once columns were promoted, replaces Pipeline.Schema for the rest of the run
+	schema *Schema
+}
+
+//ValidUnknownMode reports whether mode is one of the Unknown constants
+func ValidUnknownMode(mode string) bool {
+	switch mode {
+	case "", UnknownIgnore, UnknownExtras, UnknownPromote, UnknownReport:
+		return true
+	}
+
+	return false
+}
+
+//UnknownKeys returns how often each unmapped key was seen, by the column name it would be promoted to
+func (p *Pipeline) UnknownKeys() map[string]int64 {
+	return p.unknown.counts.snapshot()
+}
+
+//currentSchema is the schema rows are built with, it only differs from Schema while columns are being promoted
+func (p *Pipeline) currentSchema() *Schema {
+	p.unknown.mu.RLock()
+	defer p.unknown.mu.RUnlock()
+
+	if p.unknown.schema != nil {
+		return p.unknown.schema
+	}
+
+	return p.schema()
+}
+
+//unknownKeys lists the event keys and request parameters of a row that the schema does not read, sorted
+func unknownKeys(row *Row, sc *Schema, query map[string]string) []unknownKey {
+	var keys []unknownKey
+	if row.Event != nil {
+		for _, k := range row.Event.Keys() {
+			if !sc.refs[SourceEvent+"."+k] {
+				var v, _ = row.Event.Get(k)
+				keys = append(keys, unknownKey{SourceEvent, k, v})
+			}
+		}
+	}
+	for k, v := range query {
+		if !sc.refs[SourceURI+"."+k] {
+			keys = append(keys, unknownKey{SourceURI, k, v})
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ns != keys[j].ns {
+			return keys[i].ns < keys[j].ns
+		}
+		return keys[i].key < keys[j].key
+	})
+
+	return keys
+}
+
+//handleUnknown applies the Unknown mode to a row, whose schema may grow
+func (p *Pipeline) handleUnknown(logT *log.Log, reqURI *url.URL, query map[string]string, row *Row) error {
+	var keys = unknownKeys(row, row.schema, query)
+	for _, k := range keys {
+		//request parameters are counted once per request, not once per event
+		if k.ns == SourceURI && row.Index > 0 {
+			continue
+		}
+		p.unknown.counts.add(k.name(), 1)
+	}
+
+	switch p.Unknown {
+	case UnknownExtras:
+		if len(keys) == 0 {
+			return nil
+		}
+
+		var extras = make(map[string]interface{}, len(keys))
+		for _, k := range keys {
+			extras[k.name()] = k.v
+		}
+
+		b, err := json.Marshal(extras)
+		if err != nil {
+			return err
+		}
+		row.Set(SourceExtras+".json", string(b))
+	case UnknownPromote:
+		var sc, err = p.promote(keys)
+		if err != nil {
+			return err
+		}
+
+		//fill the columns promoted since the row was laid out
+		var n = len(row.Fields)
+		row.Fields = append(row.Fields, make([]string, len(sc.Columns)-n)...)
+		row.schema = sc
+		for i := n; i < len(sc.Columns); i++ {
+			var c = &sc.Columns[i]
+			if v, ok := lookup(logT, row, reqURI, query, c.ns, c.keys[0]); ok {
+				row.Fields[i] = c.format(v, p.location())
+			}
+		}
+	}
+
+	return nil
+}
+
+//promote appends a column for every key the current schema does not read yet.
+//Keys whose column name is already taken by another source are left out.
+func (p *Pipeline) promote(keys []unknownKey) (*Schema, error) {
+	p.unknown.mu.Lock()
+	defer p.unknown.mu.Unlock()
+
+	var sc = p.unknown.schema
+	if sc == nil {
+		sc = p.schema()
+	}
+
+	var cols []Column
+	for _, k := range keys {
+		if k.key == "" || sc.refs[k.ns+"."+k.key] || sc.Index(k.name()) >= 0 {
+			continue
+		}
+
+		var c = Column{Name: k.name(), Source: k.ns + "." + k.key}
+		if _, ok := k.v.(float64); ok {
+			c.Type = TypeFloat
+		}
+		cols = append(cols, c)
+	}
+	if len(cols) == 0 {
+		return sc, nil
+	}
+
+	grown, err := NewSchema(append(append([]Column(nil), sc.Columns...), cols...))
+	if err != nil {
+		return nil, fmt.Errorf("promote unknown keys: %v", err)
+	}
+	p.unknown.schema = grown
+
+	return grown, nil
+}